@@ -0,0 +1,286 @@
+package clog
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const alert_samples_default = 5
+const alert_message_bytes_max = 200
+const alert_groups_max_default = 1000
+
+type alertGroup struct {
+	caller  string
+	message string
+	level   int
+	times   []time.Time // the last records within the window, threshold at most
+	samples [][]byte    // last lines, oldest first
+	sent    time.Time   // last notification
+	count   int         // records since the last notification
+	first   time.Time   // the first of them
+	posting int32       // 1 while its alert is being posted
+}
+
+// AlertWriter posts a JSON summary to a webhook when records at or above
+// its level come in bursts: threshold records of one group within the
+// window, a group being the caller, the message or both. A group fires at
+// most once per throttle period, the summary counts the records since its
+// last alert and holds the latest lines. Alerts are posted from their own
+// goroutine, one at a time per group. At most SetMaxGroups groups are
+// tracked, records of new groups are ignored while all of them are busy.
+type AlertWriter struct {
+	writeCount
+
+	url       string
+	client    *http.Client
+	level     int
+	window    time.Duration
+	threshold int
+	throttle  time.Duration
+	byCaller  bool
+	byMessage bool
+	samples   int
+	hostname  string
+	maxGroups int
+
+	groups  map[string]*alertGroup
+	posts   sync.WaitGroup
+	postErr chan error
+}
+
+func NewAlertWriter(url string) *AlertWriter {
+	return &AlertWriter{
+		url:       url,
+		client:    &http.Client{Timeout: 5 * time.Second},
+		level:     ERROR,
+		window:    time.Minute,
+		threshold: 10,
+		throttle:  10 * time.Minute,
+		byCaller:  true,
+		samples:   alert_samples_default,
+		maxGroups: alert_groups_max_default,
+		groups:    make(map[string]*alertGroup),
+		postErr:   make(chan error, 1),
+	}
+}
+
+// SetLevel sets the lowest level watched, ERROR by default.
+func (w *AlertWriter) SetLevel(level int) {
+	w.level = level
+}
+
+// SetThreshold fires once n records of a group came within window, 10 in
+// a minute by default.
+func (w *AlertWriter) SetThreshold(n int, window time.Duration) {
+	w.threshold = n
+	w.window = window
+}
+
+// SetThrottle sets the least time between two alerts of a group, 10
+// minutes by default.
+func (w *AlertWriter) SetThrottle(d time.Duration) {
+	w.throttle = d
+}
+
+// SetGroupBy groups records by caller, by message or both, by caller by
+// default. Neither puts every record in one group.
+func (w *AlertWriter) SetGroupBy(caller, message bool) {
+	w.byCaller = caller
+	w.byMessage = message
+}
+
+// SetSamples sets how many lines an alert carries, 5 by default.
+func (w *AlertWriter) SetSamples(n int) {
+	w.samples = n
+}
+
+// SetMaxGroups bounds the groups tracked at once, 1000 by default.
+func (w *AlertWriter) SetMaxGroups(n int) {
+	w.maxGroups = n
+}
+
+func (w *AlertWriter) SetClient(client *http.Client) {
+	w.client = client
+}
+
+func (w *AlertWriter) Init() error {
+	if w.url == "" {
+		return errors.New("alert webhook url missing")
+	}
+	if w.threshold <= 0 || w.window <= 0 {
+		return errors.New("Invalid alert threshold (" + strconv.Itoa(w.threshold) + " in " + w.window.String() + ")")
+	}
+	w.hostname, _ = os.Hostname()
+	return nil
+}
+
+func (w *AlertWriter) accepts(level int) bool {
+	return atLeast(level, w.level) && level != PUBLIC
+}
+
+func (w *AlertWriter) Write(enc *textEncoder) error {
+	r := enc.rec
+	if r == nil || !w.accepts(r.level) {
+		return nil
+	}
+	caller := r.code + ":" + strconv.Itoa(r.line)
+	message := truncateString(r.message(), alert_message_bytes_max)
+	key := ""
+	if w.byCaller {
+		key = caller
+	}
+	if w.byMessage {
+		key += "\x00" + message
+	}
+	now := time.Now()
+	g := w.groups[key]
+	if g == nil {
+		if len(w.groups) >= w.maxGroups {
+			w.forget(now)
+			if len(w.groups) >= w.maxGroups {
+				return nil
+			}
+		}
+		g = &alertGroup{caller: caller, message: message}
+		w.groups[key] = g
+	}
+	g.expire(now.Add(-w.window))
+	if len(g.times) == w.threshold {
+		copy(g.times, g.times[1:])
+		g.times = g.times[:len(g.times)-1]
+	}
+	g.times = append(g.times, now)
+	if g.count++; g.count == 1 {
+		g.first = now
+	}
+	if levelRank(r.level) > levelRank(g.level) {
+		g.level = r.level
+	}
+	if w.samples > 0 {
+		line := bytes.TrimRight(enc.bytes, "\n")
+		if len(g.samples) == w.samples {
+			copy(g.samples, g.samples[1:])
+			g.samples = g.samples[:len(g.samples)-1]
+		}
+		g.samples = append(g.samples, append([]byte(nil), line...))
+	}
+
+	if len(g.times) < w.threshold || (!g.sent.IsZero() && now.Sub(g.sent) < w.throttle) {
+		return nil
+	}
+	if !atomic.CompareAndSwapInt32(&g.posting, 0, 1) {
+		// the last alert of the group is still on its way
+		return nil
+	}
+	body := w.summary(g, now)
+	n := g.count
+	g.sent = now
+	g.count = 0
+	g.level = 0
+	w.posts.Add(1)
+	go func() {
+		defer w.posts.Done()
+		if err := w.post(body); err != nil {
+			select {
+			case w.postErr <- err:
+			default:
+			}
+		} else {
+			// the records summed up by the alert
+			w.count(n, len(body))
+		}
+		atomic.StoreInt32(&g.posting, 0)
+	}()
+	if r.level == PANIC || r.level == FATAL {
+		w.posts.Wait()
+	}
+	return nil
+}
+
+// expire forgets the records before start.
+func (g *alertGroup) expire(start time.Time) {
+	i := 0
+	for i < len(g.times) && g.times[i].Before(start) {
+		i++
+	}
+	g.times = g.times[i:]
+}
+
+// Flush forgets the groups which went quiet and returns an error the last
+// alerts met.
+func (w *AlertWriter) Flush() error {
+	w.forget(time.Now())
+	select {
+	case err := <-w.postErr:
+		return err
+	default:
+		return nil
+	}
+}
+
+func (w *AlertWriter) forget(now time.Time) {
+	for key, g := range w.groups {
+		g.expire(now.Add(-w.window))
+		if len(g.times) == 0 && now.Sub(g.sent) >= w.throttle && atomic.LoadInt32(&g.posting) == 0 {
+			delete(w.groups, key)
+		}
+	}
+}
+
+// Close waits for the alerts on their way.
+func (w *AlertWriter) Close() error {
+	w.posts.Wait()
+	w.client.CloseIdleConnections()
+	return nil
+}
+
+func (w *AlertWriter) summary(g *alertGroup, now time.Time) []byte {
+	b := append([]byte(nil), `{"level":`...)
+	b = appendJSONString(b, LEVEL_FLAGS[g.level])
+	b = append(b, `,"caller":`...)
+	b = appendJSONString(b, g.caller)
+	b = append(b, `,"message":`...)
+	b = appendJSONString(b, g.message)
+	b = append(b, `,"count":`...)
+	b = strconv.AppendInt(b, int64(g.count), 10)
+	b = append(b, `,"threshold":`...)
+	b = strconv.AppendInt(b, int64(w.threshold), 10)
+	b = append(b, `,"window":`...)
+	b = appendJSONString(b, w.window.String())
+	b = append(b, `,"first":`...)
+	b = appendJSONString(b, g.first.Format(time.RFC3339Nano))
+	b = append(b, `,"last":`...)
+	b = appendJSONString(b, now.Format(time.RFC3339Nano))
+	b = append(b, `,"host":`...)
+	b = appendJSONString(b, w.hostname)
+	b = append(b, `,"samples":[`...)
+	for i, line := range g.samples {
+		if i > 0 {
+			b = append(b, ',')
+		}
+		b = appendJSONString(b, string(line))
+	}
+	return append(b, "]}"...)
+}
+
+func (w *AlertWriter) post(body []byte) error {
+	resp, err := w.client.Post(w.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("alert to %s: %s", w.url, resp.Status)
+	}
+	return nil
+}
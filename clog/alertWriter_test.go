@@ -0,0 +1,138 @@
+package clog
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func writeAlert(t *testing.T, w *AlertWriter, level, line int, msg string) {
+	r := &Record{time: time.Now(), code: "db.go", line: line, info: msg, level: level}
+	if err := w.Write(&textEncoder{bytes: []byte(msg + "\n"), level: level, rec: r}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAlertWriter(t *testing.T) {
+	var mu sync.Mutex
+	var alerts []map[string]interface{}
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		var alert map[string]interface{}
+		if err := json.NewDecoder(req.Body).Decode(&alert); err != nil {
+			t.Error(err)
+		}
+		mu.Lock()
+		alerts = append(alerts, alert)
+		mu.Unlock()
+	}))
+	defer srv.Close()
+
+	w := NewAlertWriter(srv.URL)
+	w.SetThreshold(3, time.Minute)
+	w.SetSamples(2)
+	if err := w.Init(); err != nil {
+		t.Fatal(err)
+	}
+	writeAlert(t, w, WARNING, 10, "slow")
+	for i := 0; i < 5; i++ {
+		writeAlert(t, w, ERROR, 10, "timeout")
+	}
+	writeAlert(t, w, ERROR, 20, "other")
+	w.posts.Wait()
+
+	// the third error fires, the next ones are throttled
+	mu.Lock()
+	if len(alerts) != 1 {
+		t.Fatalf("got %d alerts", len(alerts))
+	}
+	a := alerts[0]
+	mu.Unlock()
+	if a["caller"] != "db.go:10" || a["level"] != "ERROR" || a["count"] != 3.0 {
+		t.Fatalf("unexpected alert %v", a)
+	}
+	if samples := a["samples"].([]interface{}); len(samples) != 2 || samples[1] != "timeout" {
+		t.Fatalf("unexpected samples %v", samples)
+	}
+
+	// FATAL waits for its alert
+	w.SetThrottle(0)
+	writeAlert(t, w, FATAL, 10, "timeout")
+	mu.Lock()
+	defer mu.Unlock()
+	if len(alerts) != 2 || alerts[1]["count"] != 3.0 || alerts[1]["level"] != "FATAL" {
+		t.Fatalf("unexpected alerts %v", alerts)
+	}
+}
+
+func TestAlertWriterOnePostPerGroup(t *testing.T) {
+	var mu sync.Mutex
+	posts := 0
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		posts++
+		mu.Unlock()
+		<-release
+	}))
+	defer srv.Close()
+
+	w := NewAlertWriter(srv.URL)
+	w.SetThreshold(1, time.Minute)
+	w.SetThrottle(0)
+	if err := w.Init(); err != nil {
+		t.Fatal(err)
+	}
+	// the writer goes on while the webhook hangs, the group waits for it
+	for i := 0; i < 5; i++ {
+		writeAlert(t, w, ERROR, 10, "timeout")
+	}
+	writeAlert(t, w, ERROR, 20, "other")
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		mu.Lock()
+		n := posts
+		mu.Unlock()
+		if n == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("got %d posts, want one per group", n)
+		}
+	}
+	close(release)
+	w.Close()
+	mu.Lock()
+	defer mu.Unlock()
+	if posts != 2 {
+		t.Fatalf("got %d posts, want one per group", posts)
+	}
+}
+
+func TestAlertWriterMaxGroups(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {}))
+	defer srv.Close()
+
+	w := NewAlertWriter(srv.URL)
+	w.SetThreshold(5, time.Minute)
+	w.SetMaxGroups(3)
+	if err := w.Init(); err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	for line := 0; line < 10; line++ {
+		writeAlert(t, w, ERROR, line, "timeout")
+	}
+	if len(w.groups) != 3 {
+		t.Fatalf("tracking %d groups", len(w.groups))
+	}
+
+	// groups gone quiet make room for new ones
+	w.SetThreshold(5, time.Nanosecond)
+	w.SetThrottle(0)
+	writeAlert(t, w, ERROR, 42, "timeout")
+	if _, ok := w.groups["db.go:42"]; !ok || len(w.groups) != 1 {
+		t.Fatalf("tracking %d groups", len(w.groups))
+	}
+}
@@ -0,0 +1,140 @@
+package clog
+
+import "time"
+
+// Array logs val as a list, see ArrayMarshaler.
+func Array(key string, val ArrayMarshaler) Field {
+	return Field{key: key, fieldType: arrayType, obj: val}
+}
+
+func Strings(key string, vals []string) Field {
+	return Array(key, stringArray(vals))
+}
+
+func Ints(key string, vals []int) Field {
+	return Array(key, intArray(vals))
+}
+
+func Int64s(key string, vals []int64) Field {
+	return Array(key, int64Array(vals))
+}
+
+func Uint64s(key string, vals []uint64) Field {
+	return Array(key, uint64Array(vals))
+}
+
+func Float64s(key string, vals []float64) Field {
+	return Array(key, float64Array(vals))
+}
+
+func Bools(key string, vals []bool) Field {
+	return Array(key, boolArray(vals))
+}
+
+func Durations(key string, vals []time.Duration) Field {
+	return Array(key, durationArray(vals))
+}
+
+func Times(key string, vals []time.Time) Field {
+	return Array(key, timeArray(vals))
+}
+
+func Errs(key string, vals []error) Field {
+	return Array(key, errorArray(vals))
+}
+
+type stringArray []string
+
+func (a stringArray) MarshalLogArray(enc ArrayEncoder) error {
+	for _, v := range a {
+		enc.AppendString(v)
+	}
+	return nil
+}
+
+type intArray []int
+
+func (a intArray) MarshalLogArray(enc ArrayEncoder) error {
+	for _, v := range a {
+		enc.AppendInt64(int64(v))
+	}
+	return nil
+}
+
+type int64Array []int64
+
+func (a int64Array) MarshalLogArray(enc ArrayEncoder) error {
+	for _, v := range a {
+		enc.AppendInt64(v)
+	}
+	return nil
+}
+
+type uint64Array []uint64
+
+func (a uint64Array) MarshalLogArray(enc ArrayEncoder) error {
+	for _, v := range a {
+		enc.AppendUint64(v)
+	}
+	return nil
+}
+
+type float64Array []float64
+
+func (a float64Array) MarshalLogArray(enc ArrayEncoder) error {
+	for _, v := range a {
+		enc.AppendFloat64(v)
+	}
+	return nil
+}
+
+type boolArray []bool
+
+func (a boolArray) MarshalLogArray(enc ArrayEncoder) error {
+	for _, v := range a {
+		enc.AppendBool(v)
+	}
+	return nil
+}
+
+type durationArray []time.Duration
+
+func (a durationArray) MarshalLogArray(enc ArrayEncoder) error {
+	for _, v := range a {
+		enc.AppendDuration(v)
+	}
+	return nil
+}
+
+type timeArray []time.Time
+
+func (a timeArray) MarshalLogArray(enc ArrayEncoder) error {
+	for _, v := range a {
+		enc.AppendTime(v)
+	}
+	return nil
+}
+
+type errorArray []error
+
+func (a errorArray) MarshalLogArray(enc ArrayEncoder) error {
+	for _, v := range a {
+		if v == nil {
+			continue
+		}
+		if err := enc.AppendObject(newErrorInfo(v, 0)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fieldsObject is the ObjectMarshaler behind Dict.
+type fieldsObject []Field
+
+func (fs fieldsObject) MarshalLogObject(enc ObjectEncoder) error {
+	for i := range fs {
+		fs[i].AddTo(enc)
+	}
+	return nil
+}
@@ -0,0 +1,267 @@
+package clog
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+)
+
+// An audit file prefixes every record with its sequence number and a hash
+// chained over the previous one:
+//
+//	<seq> <hex hash> <record>
+//
+// hash = SHA-256 (HMAC-SHA-256 with a key) of the previous hash, the
+// decimal seq, a space and the record. The first record has seq 1 and a
+// previous hash of zeros. A newline inside a record, from a stack or a
+// Multiline field, is written as \n so that every record is one line and
+// the hash covers the record as written. The chain goes on across rotations: at each one
+// the head of the chain is saved next to the log as "<seq> <hex hash>",
+// where a writer starting on an empty file picks it up.
+const auditHeadSuffix = ".head"
+
+type auditChain struct {
+	key  []byte
+	seq  uint64
+	prev [sha256.Size]byte
+	line []byte
+}
+
+func newAuditHash(key []byte) hash.Hash {
+	if len(key) > 0 {
+		return hmac.New(sha256.New, key)
+	}
+	return sha256.New()
+}
+
+func auditSum(key []byte, prev []byte, seq uint64, record []byte) []byte {
+	h := newAuditHash(key)
+	h.Write(prev)
+	h.Write(strconv.AppendUint(nil, seq, 10))
+	h.Write([]byte{' '})
+	h.Write(record)
+	return h.Sum(nil)
+}
+
+// seal returns the audit line of record, which ends with a newline.
+func (c *auditChain) seal(record []byte) []byte {
+	record = bytes.TrimSuffix(record, []byte{'\n'})
+	c.seq++
+
+	c.line = strconv.AppendUint(c.line[:0], c.seq, 10)
+	c.line = append(c.line, ' ')
+	hashAt := len(c.line)
+	c.line = append(c.line, make([]byte, 2*sha256.Size+1)...)
+	recordAt := len(c.line)
+	for {
+		i := bytes.IndexByte(record, '\n')
+		if i < 0 {
+			break
+		}
+		c.line = append(c.line, record[:i]...)
+		c.line = append(c.line, '\\', 'n')
+		record = record[i+1:]
+	}
+	c.line = append(c.line, record...)
+
+	sum := auditSum(c.key, c.prev[:], c.seq, c.line[recordAt:])
+	copy(c.prev[:], sum)
+	hex.Encode(c.line[hashAt:], sum)
+	c.line[recordAt-1] = ' '
+	return append(c.line, '\n')
+}
+
+// resume continues the chain after the last complete record read from f
+// and returns where that record ends, 0 if f holds none.
+func (c *auditChain) resume(f io.Reader) (end int64, err error) {
+	var last []byte
+	var off int64
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadBytes('\n')
+		off += int64(len(line))
+		if len(line) > 0 && line[len(line)-1] == '\n' {
+			last = line
+			end = off
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	if last == nil {
+		return 0, nil
+	}
+	seq, sum, _, err := parseAuditLine(last)
+	if err != nil {
+		return 0, err
+	}
+	c.seq = seq
+	copy(c.prev[:], sum)
+	return end, nil
+}
+
+// saveHead writes the head of the chain to path, replacing it at once.
+func (c *auditChain) saveHead(path string) error {
+	b := strconv.AppendUint(nil, c.seq, 10)
+	b = append(b, ' ')
+	b = append(b, hex.EncodeToString(c.prev[:])...)
+	b = append(b, '\n')
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// loadHead continues the chain from the head saved at path, if any.
+func (c *auditChain) loadHead(path string) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	fields := bytes.Fields(b)
+	if len(fields) != 2 {
+		return errors.New("malformed audit head")
+	}
+	seq, err := strconv.ParseUint(string(fields[0]), 10, 64)
+	if err != nil {
+		return errors.New("malformed audit sequence number")
+	}
+	sum, err := hex.DecodeString(string(fields[1]))
+	if err != nil || len(sum) != sha256.Size {
+		return errors.New("malformed audit hash")
+	}
+	c.seq = seq
+	copy(c.prev[:], sum)
+	return nil
+}
+
+func parseAuditLine(line []byte) (seq uint64, sum []byte, record []byte, err error) {
+	line = bytes.TrimSuffix(line, []byte{'\n'})
+	i := bytes.IndexByte(line, ' ')
+	if i < 0 {
+		return 0, nil, nil, errors.New("malformed audit record")
+	}
+	if seq, err = strconv.ParseUint(string(line[:i]), 10, 64); err != nil {
+		return 0, nil, nil, errors.New("malformed audit sequence number")
+	}
+	rest := line[i+1:]
+	if len(rest) < 2*sha256.Size+1 || rest[2*sha256.Size] != ' ' {
+		return 0, nil, nil, errors.New("malformed audit hash")
+	}
+	if sum, err = hex.DecodeString(string(rest[:2*sha256.Size])); err != nil {
+		return 0, nil, nil, errors.New("malformed audit hash")
+	}
+	return seq, sum, rest[2*sha256.Size+1:], nil
+}
+
+// SetAudit makes w write a tamper-evident audit log, see VerifyAuditLog.
+// With a key the chain is an HMAC, so it cannot be recomputed without it.
+func (w *FileWriter) SetAudit(key []byte) {
+	w.audit = &auditChain{key: key}
+}
+
+// AuditReport is the result of VerifyAuditLog. When Broken is set, File,
+// Line and Seq locate the first record which failed and Reason says why.
+type AuditReport struct {
+	Records  uint64
+	FirstSeq uint64
+	LastSeq  uint64
+
+	Broken bool
+	File   string
+	Line   int
+	Seq    uint64
+	Reason string
+}
+
+func (r *AuditReport) String() string {
+	if r.Broken {
+		return fmt.Sprintf("broken at %s:%d (seq %d): %s", r.File, r.Line, r.Seq, r.Reason)
+	}
+	return fmt.Sprintf("ok, %d records, seq %d to %d", r.Records, r.FirstSeq, r.LastSeq)
+}
+
+// VerifyAuditLog checks the hash chain through the files at paths, given
+// oldest first. When the first record is not seq 1, because older files
+// were deleted, it is trusted as the start of the chain. The error is only
+// for failing to read the files.
+func VerifyAuditLog(key []byte, paths ...string) (*AuditReport, error) {
+	report := &AuditReport{}
+	var prev []byte
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			return report, err
+		}
+		err = verifyAuditFile(report, key, &prev, path, f)
+		f.Close()
+		if err != nil || report.Broken {
+			return report, err
+		}
+	}
+	return report, nil
+}
+
+func verifyAuditFile(report *AuditReport, key []byte, prev *[]byte, path string, r io.Reader) error {
+	br := bufio.NewReader(r)
+	for n := 1; ; n++ {
+		line, err := br.ReadBytes('\n')
+		if err == io.EOF && len(line) == 0 {
+			return nil
+		}
+		if err != nil && err != io.EOF {
+			return err
+		}
+		fail := func(seq uint64, reason string) {
+			report.Broken = true
+			report.File, report.Line, report.Seq, report.Reason = path, n, seq, reason
+		}
+		if err == io.EOF {
+			fail(report.LastSeq+1, "incomplete last record")
+			return nil
+		}
+		seq, sum, record, perr := parseAuditLine(line)
+		if perr != nil {
+			fail(report.LastSeq+1, perr.Error())
+			return nil
+		}
+		switch {
+		case report.Records == 0 && seq == 1:
+			*prev = make([]byte, sha256.Size)
+		case report.Records == 0:
+			// older records are gone, trust this one as the anchor
+			*prev = sum
+		case seq != report.LastSeq+1:
+			fail(seq, fmt.Sprintf("expected seq %d, records missing or reordered", report.LastSeq+1))
+			return nil
+		}
+		if report.Records > 0 || seq == 1 {
+			if !hmac.Equal(sum, auditSum(key, *prev, seq, record)) {
+				fail(seq, "hash mismatch, record altered or wrong key")
+				return nil
+			}
+		}
+		if report.Records == 0 {
+			report.FirstSeq = seq
+		}
+		report.Records++
+		report.LastSeq = seq
+		*prev = sum
+	}
+}
@@ -0,0 +1,246 @@
+package clog
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeAudit(t *testing.T, w *FileWriter, lines ...string) {
+	enc := &textEncoder{level: INFO}
+	for _, line := range lines {
+		enc.bytes = append(enc.bytes[:0], line+"\n"...)
+		if err := w.Write(enc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAuditLogChain(t *testing.T) {
+	dir, err := ioutil.TempDir("", "clog-audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "audit.log")
+	key := []byte("secret")
+
+	w := NewFileWriter()
+	w.SetFileName(name)
+	w.SetAudit(key)
+	if err := w.Init(); err != nil {
+		t.Fatal(err)
+	}
+	writeAudit(t, w, "first", "second")
+	w.file.Close()
+
+	// a new writer goes on with the chain of the file
+	w = NewFileWriter()
+	w.SetFileName(name)
+	w.SetAudit(key)
+	if err := w.Init(); err != nil {
+		t.Fatal(err)
+	}
+	writeAudit(t, w, "third")
+	w.file.Close()
+
+	report, err := VerifyAuditLog(key, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Broken || report.Records != 3 || report.LastSeq != 3 {
+		t.Fatalf("unexpected report: %v", report)
+	}
+
+	if report, _ = VerifyAuditLog([]byte("other"), name); !report.Broken {
+		t.Fatal("wrong key verified")
+	}
+
+	content, _ := ioutil.ReadFile(name)
+	tampered := bytes.Replace(content, []byte("second"), []byte("secund"), 1)
+	ioutil.WriteFile(name, tampered, 0644)
+	report, err = VerifyAuditLog(key, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Broken || report.Line != 2 || report.Seq != 2 {
+		t.Fatalf("tampering not found at line 2: %v", report)
+	}
+
+	lines := bytes.SplitAfter(content, []byte("\n"))
+	ioutil.WriteFile(name, append(lines[0], lines[2]...), 0644)
+	report, _ = VerifyAuditLog(key, name)
+	if !report.Broken || report.Seq != 3 {
+		t.Fatalf("missing record not found: %v", report)
+	}
+}
+
+func TestAuditLogResumeAfterRotation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "clog-audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "audit.log")
+	key := []byte("secret")
+
+	open := func() *FileWriter {
+		w := NewFileWriter()
+		w.SetFileName(name)
+		if err := w.SetPathPattern(name + ".%Y"); err != nil {
+			t.Fatal(err)
+		}
+		w.SetAudit(key)
+		if err := w.Init(); err != nil {
+			t.Fatal(err)
+		}
+		return w
+	}
+	w := open()
+	writeAudit(t, w, "first", "second")
+	w.variables[0] = 0 // make the year change
+	if err := w.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	w.file.Close()
+
+	// restarted on the empty file, the chain goes on from the rotated one
+	w = open()
+	writeAudit(t, w, "third")
+	w.file.Close()
+
+	report, err := VerifyAuditLog(key, name+".0", name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Broken || report.Records != 3 || report.FirstSeq != 1 || report.LastSeq != 3 {
+		t.Fatalf("unexpected report: %v", report)
+	}
+}
+
+func TestAuditLogTornRecord(t *testing.T) {
+	dir, err := ioutil.TempDir("", "clog-audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "audit.log")
+
+	w := NewFileWriter()
+	w.SetFileName(name)
+	w.SetAudit(nil)
+	if err := w.Init(); err != nil {
+		t.Fatal(err)
+	}
+	writeAudit(t, w, "first", "second")
+	// a crash cut the last record
+	w.fileBufWriter.WriteString("3 0123")
+	w.Flush()
+	w.file.Close()
+
+	w = NewFileWriter()
+	w.SetFileName(name)
+	w.SetAudit(nil)
+	if err := w.Init(); err != nil {
+		t.Fatal(err)
+	}
+	writeAudit(t, w, "third")
+	w.file.Close()
+
+	report, err := VerifyAuditLog(nil, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Broken || report.Records != 3 {
+		t.Fatalf("unexpected report: %v", report)
+	}
+}
+
+func TestAuditLogMultilineRecords(t *testing.T) {
+	dir, err := ioutil.TempDir("", "clog-audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "audit.log")
+	key := []byte("secret")
+
+	open := func() *FileWriter {
+		w := NewFileWriter()
+		w.SetFileName(name)
+		w.SetAudit(key)
+		if err := w.Init(); err != nil {
+			t.Fatal(err)
+		}
+		return w
+	}
+	l := NewLogger()
+	l.SetStacktraceLevel(ERROR)
+	l.Register(open())
+	l.deliverRecordToWriterHight(ERROR, "failed", Multiline("body", "line one\nline two"))
+	l.deliverRecordToWriterHight(INFO, "next")
+	l.Close()
+
+	report, err := VerifyAuditLog(key, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Broken || report.Records != 2 {
+		t.Fatalf("unexpected report: %v", report)
+	}
+
+	// the chain resumes after the multiline records
+	w := open()
+	writeAudit(t, w, "third")
+	w.file.Close()
+	report, _ = VerifyAuditLog(key, name)
+	if report.Broken || report.Records != 3 || report.LastSeq != 3 {
+		t.Fatalf("unexpected report after reopening: %v", report)
+	}
+}
+
+func TestAuditHeadNotDeleted(t *testing.T) {
+	dir, err := ioutil.TempDir("", "clog-audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "audit.log")
+
+	w := NewFileWriter()
+	w.SetFileName(name)
+	if err := w.SetPathPattern(name + ".%Y"); err != nil {
+		t.Fatal(err)
+	}
+	w.SetAudit(nil)
+	w.SetLogRoot(dir)
+	w.SetLogDeleteCycle(60)
+	if err := w.Init(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { w.file.Close() }()
+	writeAudit(t, w, "first")
+	w.variables[0] = 0
+	if err := w.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-time.Hour)
+	os.Chtimes(name+".0", old, old)
+	os.Chtimes(name+auditHeadSuffix, old, old)
+
+	if err := w.Delete(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(name + ".0"); !os.IsNotExist(err) {
+		t.Fatalf("expired file kept: %v", err)
+	}
+	if _, err := os.Stat(name + auditHeadSuffix); err != nil {
+		t.Fatalf("audit head deleted: %v", err)
+	}
+}
@@ -0,0 +1,33 @@
+package clog
+
+import "time"
+
+// backoff spaces reconnection attempts of network writers, doubling the
+// wait after each failure from min up to max.
+type backoff struct {
+	min, max time.Duration
+	cur      time.Duration
+	next     time.Time
+}
+
+func newBackoff(min, max time.Duration) backoff {
+	return backoff{min: min, max: max}
+}
+
+func (b *backoff) ready(now time.Time) bool {
+	return !now.Before(b.next)
+}
+
+func (b *backoff) fail(now time.Time) {
+	if b.cur == 0 {
+		b.cur = b.min
+	} else if b.cur *= 2; b.cur > b.max {
+		b.cur = b.max
+	}
+	b.next = now.Add(b.cur)
+}
+
+func (b *backoff) reset() {
+	b.cur = 0
+	b.next = time.Time{}
+}
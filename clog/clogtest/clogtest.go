@@ -0,0 +1,104 @@
+// Package clogtest captures what a clog logger writes so tests can assert
+// on records, their levels and fields.
+//
+//	logger, logs := clogtest.New(t, clog.DEBUG)
+//	handler := NewHandler(logger)
+//	...
+//	logs.ExpectOne(clog.ERROR, "timeout", clog.String("peer", "db1"))
+package clogtest
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/forge1yc/clog/clog"
+)
+
+// Logs holds the records of a logger made by New.
+type Logs struct {
+	*clog.Observer
+	t testing.TB
+}
+
+// New returns a synchronous logger at level and the records it writes,
+// the logger is closed when the test ends.
+func New(t testing.TB, level int) (*clog.Logger, *Logs) {
+	l, o := clog.NewObservedLogger(level)
+	t.Cleanup(func() { l.Close() })
+	return l, &Logs{Observer: o, t: t}
+}
+
+// Match returns the records at level whose message contains substr and
+// which hold every field given.
+func (logs *Logs) Match(level int, substr string, fields ...clog.Field) []clog.Record {
+	return logs.Filter(func(r *clog.Record) bool {
+		if r.Level() != level || !strings.Contains(r.Message(), substr) {
+			return false
+		}
+		for _, f := range fields {
+			if !r.HasField(f) {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+// ExpectOne fails the test unless exactly one record matches, see Match,
+// and returns it.
+func (logs *Logs) ExpectOne(level int, substr string, fields ...clog.Field) clog.Record {
+	logs.t.Helper()
+	found := logs.Match(level, substr, fields...)
+	if len(found) != 1 {
+		logs.t.Fatalf("want one %s record %q with %s, got %d in:\n%s",
+			clog.LEVEL_FLAGS[level], substr, describe(fields), len(found), logs.dump())
+		return clog.Record{}
+	}
+	return found[0]
+}
+
+// ExpectNone fails the test if a record matches, see Match.
+func (logs *Logs) ExpectNone(level int, substr string, fields ...clog.Field) {
+	logs.t.Helper()
+	if found := logs.Match(level, substr, fields...); len(found) > 0 {
+		logs.t.Fatalf("want no %s record %q with %s, got %d in:\n%s",
+			clog.LEVEL_FLAGS[level], substr, describe(fields), len(found), logs.dump())
+	}
+}
+
+// ExpectLen fails the test unless n records were kept.
+func (logs *Logs) ExpectLen(n int) {
+	logs.t.Helper()
+	if got := logs.Len(); got != n {
+		logs.t.Fatalf("want %d records, got %d in:\n%s", n, got, logs.dump())
+	}
+}
+
+func describe(fields []clog.Field) string {
+	if len(fields) == 0 {
+		return "any fields"
+	}
+	parts := make([]string, len(fields))
+	for i, f := range fields {
+		parts[i] = f.Key() + "=" + f.Value()
+	}
+	return strings.Join(parts, " ")
+}
+
+func (logs *Logs) dump() string {
+	var b strings.Builder
+	for _, r := range logs.All() {
+		b.WriteString("\t[")
+		b.WriteString(clog.LEVEL_FLAGS[r.Level()])
+		b.WriteString("] ")
+		b.WriteString(r.Message())
+		for _, f := range r.Fields() {
+			b.WriteString(" ")
+			b.WriteString(f.Key())
+			b.WriteString("=")
+			b.WriteString(f.Value())
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
@@ -0,0 +1,22 @@
+package clogtest
+
+import (
+	"testing"
+
+	"github.com/forge1yc/clog/clog"
+)
+
+func TestLogs(t *testing.T) {
+	logger, logs := New(t, clog.INFO)
+	logger.Debug("hidden")
+	logger.Info("connected to %s", "db1")
+	logger.HighError("query failed", clog.String("table", "users"), clog.Int("code", 40))
+
+	logs.ExpectLen(2)
+	r := logs.ExpectOne(clog.ERROR, "failed", clog.Int("code", 40))
+	if file, _ := r.Caller(); file != "clogtest_test.go" {
+		t.Fatalf("caller %q", file)
+	}
+	logs.ExpectOne(clog.INFO, "db1")
+	logs.ExpectNone(clog.ERROR, "", clog.String("table", "orders"))
+}
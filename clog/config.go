@@ -2,7 +2,10 @@ package clog
 
 import (
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
+	"strings"
+	"time"
 )
 
 type ConFileWriter struct {
@@ -14,6 +17,10 @@ type ConFileWriter struct {
 	RotateWfLogPath     string `json:"RotateWfLogPath"`
 	PublicLogPath       string `json:"PublicLogPath"`
 	RotatePublicLogPath string `json:"RotatePublicLogPath"`
+	AuditLogPath        string `json:"AuditLogPath"`
+	RotateAuditLogPath  string `json:"RotateAuditLogPath"`
+	AuditKey            string `json:"AuditKey"`   // file:PATH or env:NAME, HMAC key
+	EncryptKey          string `json:"EncryptKey"` // file:PATH or env:NAME, AES key
 	Root                string `json:"root"`
 }
 
@@ -22,10 +29,89 @@ type ConfConsoleWriter struct {
 	Color bool `json:"Color"`
 }
 
+type ConfSyslogWriter struct {
+	On       bool   `json:"On"`
+	Network  string `json:"Network"` // "unixgram", "unix", "udp", "tcp", empty for the local daemon
+	Addr     string `json:"Addr"`
+	Tag      string `json:"Tag"`
+	Facility string `json:"Facility"` // e.g. "user", "daemon", "local0"
+	RFC3164  bool   `json:"RFC3164"`
+	Level    string `json:"Level"`  // floor, all levels by default
+	Public   int    `json:"Public"` // severity of PUBLIC records, 5 by default
+}
+
+type ConfNetWriter struct {
+	On            bool   `json:"On"`
+	Network       string `json:"Network"` // "tcp", "udp", "unix" or "unixgram"
+	Addr          string `json:"Addr"`
+	OctetCounting bool   `json:"OctetCounting"`
+	MemoryLimit   int    `json:"MemoryLimit"` // bytes kept in memory while disconnected
+	Spool         string `json:"Spool"`       // file records spill to past MemoryLimit
+	SpoolSize     int64  `json:"SpoolSize"`
+	Level         string `json:"Level"` // floor, all levels by default
+}
+
+type ConfHTTPWriter struct {
+	On            bool              `json:"On"`
+	URL           string            `json:"URL"`
+	Payload       string            `json:"Payload"` // "loki", "elasticsearch" or "json"
+	Index         string            `json:"Index"`   // elasticsearch index
+	Labels        map[string]string `json:"Labels"`
+	Headers       map[string]string `json:"Headers"`
+	Gzip          bool              `json:"Gzip"`
+	BatchSize     int               `json:"BatchSize"`
+	BatchInterval string            `json:"BatchInterval"` // e.g. "2s"
+	Retries       *int              `json:"Retries"`
+	Level         string            `json:"Level"` // floor, all levels by default
+}
+
+type ConfJournaldWriter struct {
+	On         bool   `json:"On"`
+	Identifier string `json:"Identifier"` // SYSLOG_IDENTIFIER, the program name by default
+	Level      string `json:"Level"`      // floor, all levels by default
+}
+
+type ConfAlertWriter struct {
+	On        bool   `json:"On"`
+	URL       string `json:"URL"`
+	Level     string `json:"Level"`     // lowest level watched, "error" by default
+	Threshold int    `json:"Threshold"` // records of a group within Window
+	Window    string `json:"Window"`    // e.g. "1m"
+	Throttle  string `json:"Throttle"`  // least time between alerts of a group, e.g. "10m"
+	GroupBy   string `json:"GroupBy"`   // "caller", "message" or "caller,message"
+	Samples   *int   `json:"Samples"`   // lines carried by an alert
+	MaxGroups int    `json:"MaxGroups"` // groups tracked at once, 1000 by default
+}
+
+type ConfRedactPattern struct {
+	Regexp  string `json:"Regexp"`
+	Replace string `json:"Replace"`
+}
+
+type ConfRedact struct {
+	Keys     []string            `json:"Keys"`     // e.g. "password", "authorization"
+	HashKey  string              `json:"HashKey"`  // file:PATH or env:NAME, HMAC key hashing values of Keys instead of masking
+	Builtin  []string            `json:"Builtin"`  // "card", "email", "bearer"
+	Patterns []ConfRedactPattern `json:"Patterns"` // scrubbed from messages and strings
+}
+
 type LogConfig struct {
-	Level string            `json:"LogLevel"`
-	FW    ConFileWriter     `json:"FileWriter"`
-	CW    ConfConsoleWriter `json:"ConsoleWriter"`
+	Level  string             `json:"LogLevel"`
+	Levels map[string]string  `json:"Levels"`  // named logger levels, e.g. "db.pool":"trace"
+	VMod   string             `json:"VModule"` // per source file levels, e.g. "handler*=debug"
+	Stacks bool               `json:"FatalStackDump"`
+	Sync   bool               `json:"Synchronous"`     // write records before log calls return
+	Trace  string             `json:"StacktraceLevel"` // attach stacks at or above, e.g. "error"
+	Raw    bool               `json:"NoEscape"`        // write control characters as is
+	Limits Limits             `json:"Limits"`
+	Redact *ConfRedact        `json:"Redact"`
+	FW     ConFileWriter      `json:"FileWriter"`
+	CW     ConfConsoleWriter  `json:"ConsoleWriter"`
+	SW     ConfSyslogWriter   `json:"SyslogWriter"`
+	NW     ConfNetWriter      `json:"NetWriter"`
+	HW     ConfHTTPWriter     `json:"HTTPWriter"`
+	JW     ConfJournaldWriter `json:"JournaldWriter"`
+	AW     ConfAlertWriter    `json:"AlertWriter"`
 }
 
 func SetupLogWithConf(file string) (err error) {
@@ -64,6 +150,13 @@ func SetupLogWithConf(file string) (err error) {
 	}
 
 	if lc.FW.On {
+		var encKey []byte
+		if len(lc.FW.EncryptKey) > 0 {
+			if encKey, err = LoadKey(lc.FW.EncryptKey); err != nil {
+				return
+			}
+		}
+
 		if len(lc.FW.LogPath) > 0 {
 			w := NewFileWriter()
 			w.SetFileName(lc.FW.LogPath)
@@ -75,9 +168,16 @@ func SetupLogWithConf(file string) (err error) {
 			if len(lc.FW.WfLogPath) > 0 {
 				w.SetLogLevelCeil(INFO)
 			} else {
-				w.SetLogLevelCeil(ERROR)
+				w.SetLogLevelCeil(FATAL)
+			}
+			if encKey != nil {
+				if err = w.SetEncryptKey(encKey); err != nil {
+					return
+				}
+			}
+			if err = RegisterErr(w); err != nil {
+				return
 			}
-			Register(w)
 		}
 
 		if len(lc.FW.WfLogPath) > 0 {
@@ -86,9 +186,16 @@ func SetupLogWithConf(file string) (err error) {
 			wfw.SetPathPattern(lc.FW.RotateWfLogPath)
 			wfw.SetLogDeleteCycle(lc.FW.DeleteCycle)
 			wfw.SetLogLevelFloor(WARNING)
-			wfw.SetLogLevelCeil(ERROR)
+			wfw.SetLogLevelCeil(FATAL)
 			wfw.SetLogRoot(lc.FW.Root)
-			Register(wfw)
+			if encKey != nil {
+				if err = wfw.SetEncryptKey(encKey); err != nil {
+					return
+				}
+			}
+			if err = RegisterErr(wfw); err != nil {
+				return
+			}
 		}
 
 		if len(lc.FW.PublicLogPath) > 0 {
@@ -99,37 +206,259 @@ func SetupLogWithConf(file string) (err error) {
 			pw.SetLogLevelFloor(PUBLIC)
 			pw.SetLogLevelCeil(PUBLIC)
 			pw.SetLogRoot(lc.FW.Root)
-			Register(pw)
+			if encKey != nil {
+				if err = pw.SetEncryptKey(encKey); err != nil {
+					return
+				}
+			}
+			if err = RegisterErr(pw); err != nil {
+				return
+			}
+		}
+
+		if len(lc.FW.AuditLogPath) > 0 {
+			aw := NewFileWriter()
+			aw.SetFileName(lc.FW.AuditLogPath)
+			aw.SetPathPattern(lc.FW.RotateAuditLogPath)
+			aw.SetLogDeleteCycle(lc.FW.DeleteCycle)
+			aw.SetLogLevelFloor(TRACE)
+			aw.SetLogLevelCeil(PUBLIC)
+			aw.SetLogRoot(lc.FW.Root)
+			var key []byte
+			if len(lc.FW.AuditKey) > 0 {
+				if key, err = LoadKey(lc.FW.AuditKey); err != nil {
+					return
+				}
+			}
+			aw.SetAudit(key)
+			if encKey != nil {
+				if err = aw.SetEncryptKey(encKey); err != nil {
+					return
+				}
+			}
+			if err = RegisterErr(aw); err != nil {
+				return
+			}
 		}
 	}
 
 	if lc.CW.On {
 		w := NewConsoleWriter()
-		Register(w)
+		if err = RegisterErr(w); err != nil {
+			return
+		}
+	}
+
+	if lc.SW.On {
+		w := NewSyslogWriter(lc.SW.Network, lc.SW.Addr, lc.SW.Tag)
+		if len(lc.SW.Facility) > 0 {
+			facility, ok := ParseFacility(lc.SW.Facility)
+			if !ok {
+				return fmt.Errorf("invalid syslog facility %q", lc.SW.Facility)
+			}
+			w.SetFacility(facility)
+		}
+		w.SetRFC3164(lc.SW.RFC3164)
+		if len(lc.SW.Level) > 0 {
+			lvl, ok := ParseLevel(lc.SW.Level)
+			if !ok {
+				return fmt.Errorf("invalid syslog level %q", lc.SW.Level)
+			}
+			w.SetLogLevelFloor(lvl)
+		}
+		if lc.SW.Public > 0 {
+			w.SetPublicSeverity(lc.SW.Public)
+		}
+		if err = RegisterErr(w); err != nil {
+			return
+		}
+	}
+
+	if lc.NW.On {
+		w := NewNetWriter(lc.NW.Network, lc.NW.Addr)
+		w.SetOctetCounting(lc.NW.OctetCounting)
+		if lc.NW.MemoryLimit > 0 {
+			w.SetMemoryLimit(lc.NW.MemoryLimit)
+		}
+		if len(lc.NW.Spool) > 0 {
+			w.SetSpool(lc.NW.Spool, lc.NW.SpoolSize)
+		}
+		if len(lc.NW.Level) > 0 {
+			lvl, ok := ParseLevel(lc.NW.Level)
+			if !ok {
+				return fmt.Errorf("invalid net writer level %q", lc.NW.Level)
+			}
+			w.SetLogLevelFloor(lvl)
+		}
+		if err = RegisterErr(w); err != nil {
+			return
+		}
 	}
 
-	switch lc.Level {
+	if lc.HW.On {
+		var builder PayloadBuilder
+		switch lc.HW.Payload {
+		case "loki":
+			builder = LokiPayload{}
+		case "elasticsearch":
+			builder = ElasticPayload{Index: lc.HW.Index}
+		case "json", "":
+			builder = JSONArrayPayload{}
+		default:
+			return fmt.Errorf("invalid http writer payload %q", lc.HW.Payload)
+		}
+		w := NewHTTPWriter(lc.HW.URL, builder)
+		w.SetLabels(lc.HW.Labels)
+		for k, v := range lc.HW.Headers {
+			w.SetHeader(k, v)
+		}
+		w.SetGzip(lc.HW.Gzip)
+		var interval time.Duration
+		if len(lc.HW.BatchInterval) > 0 {
+			if interval, err = time.ParseDuration(lc.HW.BatchInterval); err != nil {
+				return
+			}
+		}
+		w.SetBatch(lc.HW.BatchSize, interval)
+		if lc.HW.Retries != nil {
+			w.SetRetries(*lc.HW.Retries)
+		}
+		if len(lc.HW.Level) > 0 {
+			lvl, ok := ParseLevel(lc.HW.Level)
+			if !ok {
+				return fmt.Errorf("invalid http writer level %q", lc.HW.Level)
+			}
+			w.SetLogLevelFloor(lvl)
+		}
+		if err = RegisterErr(w); err != nil {
+			return
+		}
+	}
 
-	case "trace":
-		SetLevel(TRACE)
+	if lc.JW.On {
+		w := NewJournaldWriter(lc.JW.Identifier)
+		if len(lc.JW.Level) > 0 {
+			lvl, ok := ParseLevel(lc.JW.Level)
+			if !ok {
+				return fmt.Errorf("invalid journald level %q", lc.JW.Level)
+			}
+			w.SetLogLevelFloor(lvl)
+		}
+		if err = RegisterErr(w); err != nil {
+			return
+		}
+	}
 
-	case "debug":
-		SetLevel(DEBUG)
+	if lc.AW.On {
+		w := NewAlertWriter(lc.AW.URL)
+		if len(lc.AW.Level) > 0 {
+			lvl, ok := ParseLevel(lc.AW.Level)
+			if !ok {
+				return fmt.Errorf("invalid alert level %q", lc.AW.Level)
+			}
+			w.SetLevel(lvl)
+		}
+		if lc.AW.Threshold > 0 || len(lc.AW.Window) > 0 {
+			n, window := lc.AW.Threshold, time.Minute
+			if n <= 0 {
+				n = 10
+			}
+			if len(lc.AW.Window) > 0 {
+				if window, err = time.ParseDuration(lc.AW.Window); err != nil {
+					return
+				}
+			}
+			w.SetThreshold(n, window)
+		}
+		if len(lc.AW.Throttle) > 0 {
+			var throttle time.Duration
+			if throttle, err = time.ParseDuration(lc.AW.Throttle); err != nil {
+				return
+			}
+			w.SetThrottle(throttle)
+		}
+		if len(lc.AW.GroupBy) > 0 {
+			var byCaller, byMessage bool
+			for _, key := range strings.Split(lc.AW.GroupBy, ",") {
+				switch strings.TrimSpace(key) {
+				case "caller":
+					byCaller = true
+				case "message":
+					byMessage = true
+				default:
+					return fmt.Errorf("invalid alert GroupBy %q", lc.AW.GroupBy)
+				}
+			}
+			w.SetGroupBy(byCaller, byMessage)
+		}
+		if lc.AW.Samples != nil {
+			w.SetSamples(*lc.AW.Samples)
+		}
+		if lc.AW.MaxGroups > 0 {
+			w.SetMaxGroups(lc.AW.MaxGroups)
+		}
+		if err = RegisterErr(w); err != nil {
+			return
+		}
+	}
 
-	case "info":
-		SetLevel(INFO)
+	if lvl, ok := ParseLevel(lc.Level); ok {
+		SetLevel(lvl)
+	}
 
-	case "warning":
-		SetLevel(WARNING)
+	if len(lc.Levels) > 0 {
+		levels := make(map[string]int, len(lc.Levels))
+		for module, name := range lc.Levels {
+			lvl, ok := ParseLevel(name)
+			if !ok {
+				return fmt.Errorf("invalid level %q for logger %q", name, module)
+			}
+			levels[module] = lvl
+		}
+		SetModuleLevels(levels)
+	}
 
-	case "error":
-		SetLevel(ERROR)
+	SetFatalStackDump(lc.Stacks)
+	SetSynchronous(lc.Sync)
 
-	case "fatal":
-		SetLevel(FATAL)
+	SetEscaping(!lc.Raw)
+	SetLimits(lc.Limits)
 
-	case "public":
-		SetLevel(PUBLIC)
+	if len(lc.Trace) > 0 {
+		lvl, ok := ParseLevel(lc.Trace)
+		if !ok {
+			return fmt.Errorf("invalid StacktraceLevel %q", lc.Trace)
+		}
+		SetStacktraceLevel(lvl)
+	}
+
+	if lc.Redact != nil {
+		rd := NewRedactor()
+		rd.AddKeys(lc.Redact.Keys...)
+		if len(lc.Redact.HashKey) > 0 {
+			var key []byte
+			if key, err = LoadKey(lc.Redact.HashKey); err != nil {
+				return
+			}
+			rd.SetHashKey(key)
+		}
+		for _, name := range lc.Redact.Builtin {
+			if err = rd.AddBuiltin(name); err != nil {
+				return
+			}
+		}
+		for _, p := range lc.Redact.Patterns {
+			if err = rd.AddPattern(p.Regexp, p.Replace); err != nil {
+				return
+			}
+		}
+		SetRedactor(rd)
+	}
+
+	if len(lc.VMod) > 0 {
+		if err = SetVModule(lc.VMod); err != nil {
+			return
+		}
 	}
 
 	return
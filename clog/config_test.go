@@ -2,6 +2,9 @@ package clog
 
 import (
 	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 )
 
@@ -14,3 +17,21 @@ func TestSetupLogWithConf(t *testing.T) {
 	}
 
 }
+
+func TestSetupLogWithConfWriterError(t *testing.T) {
+	dir, err := ioutil.TempDir("", "clog-conf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	// a file where the log directory should be
+	blocked := filepath.Join(dir, "blocked")
+	ioutil.WriteFile(blocked, nil, 0644)
+	conf := filepath.Join(dir, "clog.json")
+	ioutil.WriteFile(conf, []byte(`{"LogLevel":"info","FileWriter":{"On":true,"LogPath":"`+
+		filepath.Join(blocked, "service.log")+`"}}`), 0644)
+
+	if err := SetupLogWithConf(conf); err == nil {
+		t.Fatal("unopenable log file not reported")
+	}
+}
@@ -6,6 +6,7 @@ import (
 )
 
 type ConsoleWriter struct {
+	writeCount
 }
 
 func NewConsoleWriter() *ConsoleWriter {
@@ -13,7 +14,11 @@ func NewConsoleWriter() *ConsoleWriter {
 }
 
 func (w *ConsoleWriter) Write(enc *textEncoder) error {
-	fmt.Fprint(os.Stdout, string(enc.bytes))
+	n, err := fmt.Fprint(os.Stdout, string(enc.bytes))
+	if err != nil {
+		return err
+	}
+	w.count(1, n)
 
 	// 可以自己修改颜色，这里可以进行配置的
 	//blue := color.New(color.FgBlue)
@@ -27,3 +32,8 @@ func (w *ConsoleWriter) Write(enc *textEncoder) error {
 func (w *ConsoleWriter) Init() error {
 	return nil
 }
+
+// Close leaves stdout open, nothing is buffered.
+func (w *ConsoleWriter) Close() error {
+	return nil
+}
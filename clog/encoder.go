@@ -0,0 +1,428 @@
+package clog
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ObjectMarshaler is implemented by types which write themselves as a set
+// of key/value pairs, into JSON or the text format alike.
+type ObjectMarshaler interface {
+	MarshalLogObject(ObjectEncoder) error
+}
+
+// ArrayMarshaler is implemented by types which write themselves as a list
+// of values.
+type ArrayMarshaler interface {
+	MarshalLogArray(ArrayEncoder) error
+}
+
+// ObjectMarshalerFunc turns a function into an ObjectMarshaler.
+type ObjectMarshalerFunc func(ObjectEncoder) error
+
+func (f ObjectMarshalerFunc) MarshalLogObject(enc ObjectEncoder) error {
+	return f(enc)
+}
+
+// ArrayMarshalerFunc turns a function into an ArrayMarshaler.
+type ArrayMarshalerFunc func(ArrayEncoder) error
+
+func (f ArrayMarshalerFunc) MarshalLogArray(enc ArrayEncoder) error {
+	return f(enc)
+}
+
+// ObjectEncoder is what an ObjectMarshaler writes its pairs into.
+type ObjectEncoder interface {
+	AddString(key, val string)
+	AddInt64(key string, val int64)
+	AddUint64(key string, val uint64)
+	AddFloat64(key string, val float64)
+	AddBool(key string, val bool)
+	AddTime(key string, val time.Time)
+	AddDuration(key string, val time.Duration)
+	AddObject(key string, obj ObjectMarshaler) error
+	AddArray(key string, arr ArrayMarshaler) error
+	// AddReflected is the slow path, encoding val with encoding/json or %+v.
+	AddReflected(key string, val interface{}) error
+	// OpenNamespace nests every pair added after it, until the end of the
+	// enclosing object, under key.
+	OpenNamespace(key string)
+}
+
+// ArrayEncoder is what an ArrayMarshaler writes its values into.
+type ArrayEncoder interface {
+	AppendString(val string)
+	AppendInt64(val int64)
+	AppendUint64(val uint64)
+	AppendFloat64(val float64)
+	AppendBool(val bool)
+	AppendTime(val time.Time)
+	AppendDuration(val time.Duration)
+	AppendObject(obj ObjectMarshaler) error
+	AppendArray(arr ArrayMarshaler) error
+	AppendReflected(val interface{}) error
+}
+
+// jsonEncoder appends JSON to bytes, placing commas by looking at the last
+// byte written.
+type jsonEncoder struct {
+	bytes          []byte
+	openNamespaces int
+	maxString      int // Limits.MaxField, 0 for none
+}
+
+var jsonPool = sync.Pool{New: func() interface{} {
+	return &jsonEncoder{}
+}}
+
+func getJSONEncoder(b []byte) *jsonEncoder {
+	enc := jsonPool.Get().(*jsonEncoder)
+	enc.bytes = b
+	enc.openNamespaces = 0
+	enc.maxString = 0
+	return enc
+}
+
+// putJSONEncoder returns enc to its pool and the bytes it appended to.
+func putJSONEncoder(enc *jsonEncoder) []byte {
+	b := enc.bytes
+	enc.bytes = nil
+	jsonPool.Put(enc)
+	return b
+}
+
+func (enc *jsonEncoder) separator() {
+	if n := len(enc.bytes); n > 0 {
+		switch enc.bytes[n-1] {
+		case '{', '[', ':', ',':
+		default:
+			enc.bytes = append(enc.bytes, ',')
+		}
+	}
+}
+
+func (enc *jsonEncoder) key(key string) {
+	enc.separator()
+	enc.bytes = appendJSONString(enc.bytes, key)
+	enc.bytes = append(enc.bytes, ':')
+}
+
+func (enc *jsonEncoder) closeNamespaces() {
+	for ; enc.openNamespaces > 0; enc.openNamespaces-- {
+		enc.bytes = append(enc.bytes, '}')
+	}
+}
+
+func (enc *jsonEncoder) AddString(key, val string) {
+	enc.key(key)
+	enc.AppendString(val)
+}
+
+func (enc *jsonEncoder) AddInt64(key string, val int64) {
+	enc.key(key)
+	enc.AppendInt64(val)
+}
+
+func (enc *jsonEncoder) AddUint64(key string, val uint64) {
+	enc.key(key)
+	enc.AppendUint64(val)
+}
+
+func (enc *jsonEncoder) AddFloat64(key string, val float64) {
+	enc.key(key)
+	enc.AppendFloat64(val)
+}
+
+func (enc *jsonEncoder) AddBool(key string, val bool) {
+	enc.key(key)
+	enc.AppendBool(val)
+}
+
+func (enc *jsonEncoder) AddTime(key string, val time.Time) {
+	enc.key(key)
+	enc.AppendTime(val)
+}
+
+func (enc *jsonEncoder) AddDuration(key string, val time.Duration) {
+	enc.key(key)
+	enc.AppendDuration(val)
+}
+
+func (enc *jsonEncoder) AddObject(key string, obj ObjectMarshaler) error {
+	enc.key(key)
+	return enc.AppendObject(obj)
+}
+
+func (enc *jsonEncoder) AddArray(key string, arr ArrayMarshaler) error {
+	enc.key(key)
+	return enc.AppendArray(arr)
+}
+
+func (enc *jsonEncoder) AddReflected(key string, val interface{}) error {
+	enc.key(key)
+	return enc.AppendReflected(val)
+}
+
+func (enc *jsonEncoder) OpenNamespace(key string) {
+	enc.key(key)
+	enc.bytes = append(enc.bytes, '{')
+	enc.openNamespaces++
+}
+
+func (enc *jsonEncoder) AppendString(val string) {
+	enc.separator()
+	enc.bytes = appendJSONString(enc.bytes, truncateString(val, enc.maxString))
+}
+
+func (enc *jsonEncoder) AppendInt64(val int64) {
+	enc.separator()
+	enc.bytes = strconv.AppendInt(enc.bytes, val, 10)
+}
+
+func (enc *jsonEncoder) AppendUint64(val uint64) {
+	enc.separator()
+	enc.bytes = strconv.AppendUint(enc.bytes, val, 10)
+}
+
+func (enc *jsonEncoder) AppendFloat64(val float64) {
+	enc.separator()
+	enc.bytes = appendJSONFloat(enc.bytes, val)
+}
+
+func (enc *jsonEncoder) AppendBool(val bool) {
+	enc.separator()
+	enc.bytes = strconv.AppendBool(enc.bytes, val)
+}
+
+func (enc *jsonEncoder) AppendTime(val time.Time) {
+	enc.separator()
+	enc.bytes = append(enc.bytes, '"')
+	enc.bytes = val.AppendFormat(enc.bytes, time.RFC3339Nano)
+	enc.bytes = append(enc.bytes, '"')
+}
+
+func (enc *jsonEncoder) AppendDuration(val time.Duration) {
+	enc.AppendString(val.String())
+}
+
+func (enc *jsonEncoder) AppendObject(obj ObjectMarshaler) error {
+	enc.separator()
+	enc.bytes = append(enc.bytes, '{')
+	open := enc.openNamespaces
+	enc.openNamespaces = 0
+	err := obj.MarshalLogObject(enc)
+	enc.closeNamespaces()
+	enc.openNamespaces = open
+	enc.bytes = append(enc.bytes, '}')
+	return err
+}
+
+func (enc *jsonEncoder) AppendArray(arr ArrayMarshaler) error {
+	enc.separator()
+	enc.bytes = append(enc.bytes, '[')
+	err := arr.MarshalLogArray(enc)
+	enc.bytes = append(enc.bytes, ']')
+	return err
+}
+
+func (enc *jsonEncoder) AppendReflected(val interface{}) error {
+	var raw []byte
+	var err error
+	if r, ok := val.(*reflected); ok {
+		raw, err = r.raw, r.err
+	} else {
+		raw, err = json.Marshal(val)
+	}
+	if err != nil {
+		enc.AppendString(fmt.Sprintf("%+v", val))
+		return err
+	}
+	if enc.maxString > 0 && len(raw) > enc.maxString {
+		enc.AppendString(string(raw))
+		return nil
+	}
+	enc.separator()
+	enc.bytes = append(enc.bytes, raw...)
+	return nil
+}
+
+// textObjectEncoder renders nested values for the text format, objects as
+// {k=v k=v} and arrays as [v,v]. Namespaces prefix the keys after them.
+type textObjectEncoder struct {
+	bytes   []byte
+	prefix  string
+	inArray bool
+	needSep bool
+}
+
+var textObjectPool = sync.Pool{New: func() interface{} {
+	return &textObjectEncoder{}
+}}
+
+func getTextObjectEncoder(b []byte) *textObjectEncoder {
+	enc := textObjectPool.Get().(*textObjectEncoder)
+	enc.bytes = b
+	enc.prefix = ""
+	enc.inArray = false
+	enc.needSep = false
+	return enc
+}
+
+func putTextObjectEncoder(enc *textObjectEncoder) []byte {
+	b := enc.bytes
+	enc.bytes = nil
+	textObjectPool.Put(enc)
+	return b
+}
+
+// separator is written before every key and array element but the first.
+func (enc *textObjectEncoder) separator() {
+	if !enc.needSep {
+		enc.needSep = true
+		return
+	}
+	if enc.inArray {
+		enc.bytes = append(enc.bytes, ',')
+	} else {
+		enc.bytes = append(enc.bytes, ' ')
+	}
+}
+
+func (enc *textObjectEncoder) key(key string) {
+	enc.separator()
+	enc.bytes = append(enc.bytes, enc.prefix...)
+	enc.bytes = append(enc.bytes, key...)
+	enc.bytes = append(enc.bytes, '=')
+}
+
+// value is separator for array elements, keys already wrote theirs.
+func (enc *textObjectEncoder) value() {
+	if enc.inArray {
+		enc.separator()
+	}
+}
+
+func (enc *textObjectEncoder) AddString(key, val string) {
+	enc.key(key)
+	enc.bytes = append(enc.bytes, val...)
+}
+
+func (enc *textObjectEncoder) AddInt64(key string, val int64) {
+	enc.key(key)
+	enc.bytes = strconv.AppendInt(enc.bytes, val, 10)
+}
+
+func (enc *textObjectEncoder) AddUint64(key string, val uint64) {
+	enc.key(key)
+	enc.bytes = strconv.AppendUint(enc.bytes, val, 10)
+}
+
+func (enc *textObjectEncoder) AddFloat64(key string, val float64) {
+	enc.key(key)
+	enc.bytes = strconv.AppendFloat(enc.bytes, val, 'f', -1, 64)
+}
+
+func (enc *textObjectEncoder) AddBool(key string, val bool) {
+	enc.key(key)
+	enc.bytes = strconv.AppendBool(enc.bytes, val)
+}
+
+func (enc *textObjectEncoder) AddTime(key string, val time.Time) {
+	enc.key(key)
+	enc.bytes = val.AppendFormat(enc.bytes, time.RFC3339Nano)
+}
+
+func (enc *textObjectEncoder) AddDuration(key string, val time.Duration) {
+	enc.AddString(key, val.String())
+}
+
+func (enc *textObjectEncoder) AddObject(key string, obj ObjectMarshaler) error {
+	enc.key(key)
+	return enc.object(obj)
+}
+
+func (enc *textObjectEncoder) AddArray(key string, arr ArrayMarshaler) error {
+	enc.key(key)
+	return enc.array(arr)
+}
+
+func (enc *textObjectEncoder) AddReflected(key string, val interface{}) error {
+	enc.AddString(key, fmt.Sprintf("%+v", val))
+	return nil
+}
+
+func (enc *textObjectEncoder) OpenNamespace(key string) {
+	enc.prefix += key + "."
+}
+
+func (enc *textObjectEncoder) AppendString(val string) {
+	enc.value()
+	enc.bytes = append(enc.bytes, val...)
+}
+
+func (enc *textObjectEncoder) AppendInt64(val int64) {
+	enc.value()
+	enc.bytes = strconv.AppendInt(enc.bytes, val, 10)
+}
+
+func (enc *textObjectEncoder) AppendUint64(val uint64) {
+	enc.value()
+	enc.bytes = strconv.AppendUint(enc.bytes, val, 10)
+}
+
+func (enc *textObjectEncoder) AppendFloat64(val float64) {
+	enc.value()
+	enc.bytes = strconv.AppendFloat(enc.bytes, val, 'f', -1, 64)
+}
+
+func (enc *textObjectEncoder) AppendBool(val bool) {
+	enc.value()
+	enc.bytes = strconv.AppendBool(enc.bytes, val)
+}
+
+func (enc *textObjectEncoder) AppendTime(val time.Time) {
+	enc.value()
+	enc.bytes = val.AppendFormat(enc.bytes, time.RFC3339Nano)
+}
+
+func (enc *textObjectEncoder) AppendDuration(val time.Duration) {
+	enc.AppendString(val.String())
+}
+
+func (enc *textObjectEncoder) AppendObject(obj ObjectMarshaler) error {
+	enc.value()
+	return enc.object(obj)
+}
+
+func (enc *textObjectEncoder) AppendArray(arr ArrayMarshaler) error {
+	enc.value()
+	return enc.array(arr)
+}
+
+func (enc *textObjectEncoder) AppendReflected(val interface{}) error {
+	enc.AppendString(fmt.Sprintf("%+v", val))
+	return nil
+}
+
+func (enc *textObjectEncoder) object(obj ObjectMarshaler) error {
+	prefix, inArray, needSep := enc.prefix, enc.inArray, enc.needSep
+	enc.prefix, enc.inArray, enc.needSep = "", false, false
+	enc.bytes = append(enc.bytes, '{')
+	err := obj.MarshalLogObject(enc)
+	enc.bytes = append(enc.bytes, '}')
+	enc.prefix, enc.inArray, enc.needSep = prefix, inArray, needSep
+	return err
+}
+
+func (enc *textObjectEncoder) array(arr ArrayMarshaler) error {
+	inArray, needSep := enc.inArray, enc.needSep
+	enc.inArray, enc.needSep = true, false
+	enc.bytes = append(enc.bytes, '[')
+	err := arr.MarshalLogArray(enc)
+	enc.bytes = append(enc.bytes, ']')
+	enc.inArray, enc.needSep = inArray, needSep
+	return err
+}
@@ -0,0 +1,125 @@
+package clog
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+type user struct {
+	name  string
+	roles []string
+}
+
+func (u user) MarshalLogObject(enc ObjectEncoder) error {
+	enc.AddString("name", u.name)
+	return enc.AddArray("roles", Strings("", u.roles).obj.(ArrayMarshaler))
+}
+
+// renderFields returns what follows the message in the text and JSON form.
+func renderFields(t *testing.T, fields ...Field) (string, string) {
+	text, js := render("m", fields...)
+	i := strings.Index(text, "] m ")
+	j := strings.Index(js, `"msg":"m",`)
+	if i < 0 || j < 0 {
+		t.Fatalf("unexpected records %q %q", text, js)
+	}
+	text = strings.TrimSuffix(text[i+len("] m "):], "\n")
+	js = strings.TrimSuffix(strings.TrimSuffix(js[j+len(`"msg":"m",`):], "\n"), "}")
+	return text, js
+}
+
+func TestEncodeFields(t *testing.T) {
+	at := time.Date(2024, 5, 1, 10, 0, 0, 5, time.UTC)
+	tests := []struct {
+		field      Field
+		text, json string
+	}{
+		{Time("t", at), "t=2024-05-01T10:00:00.000000005Z", `"t":"2024-05-01T10:00:00.000000005Z"`},
+		{Duration("d", 1500*time.Millisecond), "d=1.5s", `"d":"1.5s"`},
+		{Strings("s", []string{"a", "b"}), "s=[a,b]", `"s":["a","b"]`},
+		{Ints("i", []int{1, -2}), "i=[1,-2]", `"i":[1,-2]`},
+		{Int64s("i", nil), "i=[]", `"i":[]`},
+		{Uint64s("u", []uint64{3}), "u=[3]", `"u":[3]`},
+		{Float64s("f", []float64{0.5}), "f=[0.5]", `"f":[0.5]`},
+		{Bools("b", []bool{true, false}), "b=[true,false]", `"b":[true,false]`},
+		{Durations("d", []time.Duration{time.Second}), "d=[1s]", `"d":["1s"]`},
+		{Times("t", []time.Time{at}), "t=[2024-05-01T10:00:00.000000005Z]", `"t":["2024-05-01T10:00:00.000000005Z"]`},
+		{Errs("e", []error{errors.New("x"), nil}), `e=[{message=x\ type=*errors.errorString}]`, `"e":[{"message":"x","type":"*errors.errorString"}]`},
+		{Dict("d", Int("a", 1), Dict("b", String("c", "v"))), `d={a=1\ b={c=v}}`, `"d":{"a":1,"b":{"c":"v"}}`},
+		{Object("u", user{"ann", []string{"admin"}}), `u={name=ann\ roles=[admin]}`, `"u":{"name":"ann","roles":["admin"]}`},
+		{Array("a", ArrayMarshalerFunc(func(enc ArrayEncoder) error {
+			enc.AppendInt64(1)
+			return enc.AppendObject(ObjectMarshalerFunc(func(enc ObjectEncoder) error {
+				enc.AddBool("ok", true)
+				return nil
+			}))
+		})), "a=[1,{ok=true}]", `"a":[1,{"ok":true}]`},
+		{Object("m", map[string]int{"a": 1}), "m=map[a:1]", `"m":{"a":1}`},
+		{Any("m", map[string]int{"a": 1}), "m=map[a:1]", `"m":{"a":1}`},
+		{Object("p", struct{ A, b int }{1, 2}), `p={A:1\ b:2}`, `"p":{"A":1}`},
+	}
+	for _, tt := range tests {
+		text, js := renderFields(t, tt.field)
+		if text != tt.text {
+			t.Errorf("%s: text %q, want %q", tt.field.key, text, tt.text)
+		}
+		if tt.json != "" && js != tt.json {
+			t.Errorf("%s: json %q, want %q", tt.field.key, js, tt.json)
+		}
+	}
+}
+
+func TestEncodeNamespace(t *testing.T) {
+	text, js := renderFields(t, String("a", "1"), Namespace("req"), Int("id", 7), Dict("h", String("k", "v")))
+	if want := "a=1 req.id=7 req.h={k=v}"; text != want {
+		t.Errorf("text %q, want %q", text, want)
+	}
+	if want := `"a":"1","req":{"id":7,"h":{"k":"v"}}`; js != want {
+		t.Errorf("json %q, want %q", js, want)
+	}
+}
+
+func TestEncodeAny(t *testing.T) {
+	tests := []struct {
+		val  interface{}
+		typ  fieldType
+		text string
+	}{
+		{true, boolType, "true"},
+		{int8(-3), int64Type, "-3"},
+		{uint16(3), uintType, "3"},
+		{float32(0.25), floatType, "0.25"},
+		{"s", stringType, "s"},
+		{[]byte("hi"), stringType, "aGk="},
+		{[]string{"a"}, arrayType, "[a]"},
+		{time.Second, durationType, "1s"},
+		{errors.New("x"), errorType, "x [*errors.errorString]"},
+		{user{name: "ann"}, objectMarshalerType, "{name=ann roles=[]}"},
+		{struct{}{}, objectType, "{}"},
+	}
+	for _, tt := range tests {
+		f := Any("k", tt.val)
+		if f.fieldType != tt.typ || string(f.WriteValue(nil)) != tt.text {
+			t.Errorf("Any(%#v) = type %d %q, want type %d %q", tt.val, f.fieldType, string(f.WriteValue(nil)), tt.typ, tt.text)
+		}
+	}
+}
+
+func TestEncodeMarshalerError(t *testing.T) {
+	failing := ObjectMarshalerFunc(func(enc ObjectEncoder) error {
+		enc.AddInt64("n", 1)
+		return errors.New("no more")
+	})
+	text, js := renderFields(t, Object("o", failing), Object("c", make(chan int)))
+	if want := `o={n=1} oError=no\ more c=`; !strings.HasPrefix(text, want) {
+		t.Errorf("text %q, want prefix %q", text, want)
+	}
+	if want := `"o":{"n":1},"oError":"no more","c":"0x`; !strings.HasPrefix(js, want) {
+		t.Errorf("json %q, want prefix %q", js, want)
+	}
+	if !strings.Contains(js, `"cError":"json: unsupported type: chan int"`) {
+		t.Errorf("json %q has no cError", js)
+	}
+}
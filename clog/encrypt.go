@@ -0,0 +1,267 @@
+package clog
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+)
+
+// An encrypted log starts with encMagic and a random file id, followed by
+// frames, each one the output of a single Write and authenticated on its
+// own:
+//
+//	4 byte big endian length of nonce and sealed data | 12 byte nonce | AES-GCM sealed data
+//
+// The additional data of a frame is the file id and the 8 byte big endian
+// index of the frame, so frames can't be reordered or moved between files.
+// A frame cut by a crash only loses itself, the frames before it still
+// decrypt, and is cut off before a writer appends to the file again.
+const encMagic = "CLOGENC2"
+
+const enc_file_id_bytes_cnt = 16
+
+// max_frame_bytes_cnt bounds the frame length accepted when decrypting.
+const max_frame_bytes_cnt = 64 << 20
+
+// ErrTruncatedLog is returned by DecryptLog after writing every complete
+// frame of a file whose last frame was cut short.
+var ErrTruncatedLog = errors.New("encrypted log ends with an incomplete frame")
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// frameAAD returns the additional data of frame index of file id.
+func frameAAD(b []byte, id []byte, index uint64) []byte {
+	b = append(b[:0], id...)
+	var n [8]byte
+	binary.BigEndian.PutUint64(n[:], index)
+	return append(b, n[:]...)
+}
+
+type encryptWriter struct {
+	w      io.Writer
+	aead   cipher.AEAD
+	header bool // encMagic and id still have to be written
+	id     []byte
+	index  uint64 // of the next frame
+	frame  []byte
+	aad    []byte
+}
+
+// newEncryptWriter starts a new file with a random id.
+func newEncryptWriter(w io.Writer, aead cipher.AEAD) (*encryptWriter, error) {
+	id := make([]byte, enc_file_id_bytes_cnt)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	return &encryptWriter{w: w, aead: aead, header: true, id: id}, nil
+}
+
+// NewEncryptWriter returns a writer sealing every Write into a frame on w,
+// after the header. key is an AES-128, 192 or 256 key.
+func NewEncryptWriter(w io.Writer, key []byte) (io.Writer, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	return newEncryptWriter(w, aead)
+}
+
+func (e *encryptWriter) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	nonceSize := e.aead.NonceSize()
+	e.frame = e.frame[:0]
+	if e.header {
+		e.frame = append(e.frame, encMagic...)
+		e.frame = append(e.frame, e.id...)
+	}
+	start := len(e.frame)
+	e.frame = append(e.frame, 0, 0, 0, 0)
+	e.frame = append(e.frame, make([]byte, nonceSize)...)
+	nonce := e.frame[start+4:]
+	if _, err := rand.Read(nonce); err != nil {
+		return 0, err
+	}
+	e.aad = frameAAD(e.aad, e.id, e.index)
+	e.frame = e.aead.Seal(e.frame, nonce, p, e.aad)
+	binary.BigEndian.PutUint32(e.frame[start:], uint32(len(e.frame)-start-4))
+
+	if _, err := e.w.Write(e.frame); err != nil {
+		return 0, err
+	}
+	e.header = false
+	e.index++
+	return len(p), nil
+}
+
+// DecryptLog writes the plain text of the encrypted log read from src to
+// dst.
+func DecryptLog(dst io.Writer, src io.Reader, key []byte) error {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return err
+	}
+	r := bufio.NewReader(src)
+	header := make([]byte, len(encMagic)+enc_file_id_bytes_cnt)
+	if _, err := io.ReadFull(r, header); err != nil {
+		if err == io.EOF {
+			return nil
+		}
+		return ErrTruncatedLog
+	}
+	if string(header[:len(encMagic)]) != encMagic {
+		return errors.New("not an encrypted clog file")
+	}
+	id := header[len(encMagic):]
+
+	var (
+		head  [4]byte
+		frame []byte
+		plain []byte
+		aad   []byte
+	)
+	for n := uint64(0); ; n++ {
+		if _, err := io.ReadFull(r, head[:]); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return ErrTruncatedLog
+		}
+		size := int(binary.BigEndian.Uint32(head[:]))
+		if size < aead.NonceSize()+aead.Overhead() || size > max_frame_bytes_cnt {
+			return fmt.Errorf("frame %d: invalid length %d", n, size)
+		}
+		if cap(frame) < size {
+			frame = make([]byte, size)
+		}
+		frame = frame[:size]
+		if _, err := io.ReadFull(r, frame); err != nil {
+			return ErrTruncatedLog
+		}
+		nonce, sealed := frame[:aead.NonceSize()], frame[aead.NonceSize():]
+		aad = frameAAD(aad, id, n)
+		if plain, err = aead.Open(plain[:0], nonce, sealed, aad); err != nil {
+			return fmt.Errorf("frame %d: %v", n, err)
+		}
+		if _, err := dst.Write(plain); err != nil {
+			return err
+		}
+	}
+}
+
+// openEncryptWriter prepares the file at path for appending encrypted
+// frames: a torn last frame is cut off and a file which is not encrypted
+// is renamed to path.plain.<unix time> so that it isn't mixed with frames.
+func openEncryptWriter(path string, aead cipher.AEAD) (*encryptWriter, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return newEncryptWriter(nil, aead)
+	}
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	header := make([]byte, len(encMagic)+enc_file_id_bytes_cnt)
+	n, _ := io.ReadFull(f, header)
+	switch {
+	case n < len(header) && isEncHeaderPrefix(header[:n]):
+		// the header itself was cut, nothing else was written
+		f.Close()
+		if err := os.Truncate(path, 0); err != nil {
+			return nil, err
+		}
+		return newEncryptWriter(nil, aead)
+	case n < len(header) || string(header[:len(encMagic)]) != encMagic:
+		f.Close()
+		aside := path + ".plain." + strconv.FormatInt(time.Now().Unix(), 10)
+		if err := os.Rename(path, aside); err != nil {
+			return nil, err
+		}
+		return newEncryptWriter(nil, aead)
+	}
+
+	e := &encryptWriter{aead: aead, id: header[len(encMagic):]}
+	end := int64(len(header))
+	var head [4]byte
+	for {
+		if _, err := f.ReadAt(head[:], end); err != nil {
+			break
+		}
+		size := int64(binary.BigEndian.Uint32(head[:]))
+		if size < int64(aead.NonceSize()+aead.Overhead()) || end+4+size > info.Size() {
+			break
+		}
+		end += 4 + size
+		e.index++
+	}
+	f.Close()
+	if end < info.Size() {
+		if err := os.Truncate(path, end); err != nil {
+			return nil, err
+		}
+	}
+	return e, nil
+}
+
+// isEncHeaderPrefix tells whether b may be the start of a header.
+func isEncHeaderPrefix(b []byte) bool {
+	if len(b) <= len(encMagic) {
+		return string(b) == encMagic[:len(b)]
+	}
+	return string(b[:len(encMagic)]) == encMagic
+}
+
+// SetEncryptKey makes w encrypt its files with AES-GCM, see DecryptLog.
+func (w *FileWriter) SetEncryptKey(key []byte) error {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return err
+	}
+	w.key = key
+	w.aead = aead
+	return nil
+}
+
+// VerifyEncryptedAuditLog is VerifyAuditLog for audit files encrypted with
+// encKey, decrypted in memory first. A torn last frame shows as an
+// incomplete last record.
+func VerifyEncryptedAuditLog(key, encKey []byte, paths ...string) (*AuditReport, error) {
+	report := &AuditReport{}
+	var prev []byte
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			return report, err
+		}
+		var plain bytes.Buffer
+		err = DecryptLog(&plain, f, encKey)
+		f.Close()
+		if err != nil && err != ErrTruncatedLog {
+			return report, fmt.Errorf("%s: %v", path, err)
+		}
+		if err = verifyAuditFile(report, key, &prev, path, &plain); err != nil || report.Broken {
+			return report, err
+		}
+	}
+	return report, nil
+}
@@ -0,0 +1,212 @@
+package clog
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var testEncKey = []byte("0123456789abcdef")
+
+func openEncrypted(t *testing.T, name string) *FileWriter {
+	w := NewFileWriter()
+	w.SetFileName(name)
+	if err := w.SetEncryptKey(testEncKey); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Init(); err != nil {
+		t.Fatal(err)
+	}
+	return w
+}
+
+func decryptFile(t *testing.T, name string) (string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var out bytes.Buffer
+	err = DecryptLog(&out, f, testEncKey)
+	return out.String(), err
+}
+
+func encTempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "clog-enc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestEncryptRoundTrip(t *testing.T) {
+	dir := encTempDir(t)
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "enc.log")
+
+	w := openEncrypted(t, name)
+	writeAudit(t, w, "first", "second")
+	w.file.Close()
+
+	content, _ := ioutil.ReadFile(name)
+	if bytes.Contains(content, []byte("first")) {
+		t.Fatal("plain text in encrypted file")
+	}
+	got, err := decryptFile(t, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "first\nsecond\n" {
+		t.Fatalf("unexpected content %q", got)
+	}
+}
+
+func TestEncryptReopen(t *testing.T) {
+	dir := encTempDir(t)
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "enc.log")
+
+	w := openEncrypted(t, name)
+	writeAudit(t, w, "first")
+	writeAudit(t, w, "second")
+	w.file.Close()
+
+	w = openEncrypted(t, name)
+	writeAudit(t, w, "third")
+	w.file.Close()
+
+	got, err := decryptFile(t, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "first\nsecond\nthird\n" {
+		t.Fatalf("unexpected content %q", got)
+	}
+
+	// swapping two frames breaks their index
+	content, _ := ioutil.ReadFile(name)
+	pos := len(encMagic) + enc_file_id_bytes_cnt
+	first := 4 + int(binary.BigEndian.Uint32(content[pos:]))
+	second := 4 + int(binary.BigEndian.Uint32(content[pos+first:]))
+	swapped := append([]byte{}, content[:pos]...)
+	swapped = append(swapped, content[pos+first:pos+first+second]...)
+	swapped = append(swapped, content[pos:pos+first]...)
+	swapped = append(swapped, content[pos+first+second:]...)
+	ioutil.WriteFile(name, swapped, 0644)
+	if _, err := decryptFile(t, name); err == nil {
+		t.Fatal("reordered frames decrypted")
+	}
+
+	// a frame of another file doesn't fit either
+	other := filepath.Join(dir, "other.log")
+	w = openEncrypted(t, other)
+	writeAudit(t, w, "first")
+	w.file.Close()
+	otherContent, _ := ioutil.ReadFile(other)
+	spliced := append(append([]byte{}, otherContent[:pos]...), content[pos:]...)
+	ioutil.WriteFile(name, spliced, 0644)
+	if _, err := decryptFile(t, name); err == nil {
+		t.Fatal("frames of another file decrypted")
+	}
+}
+
+func TestEncryptTornTail(t *testing.T) {
+	dir := encTempDir(t)
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "enc.log")
+
+	w := openEncrypted(t, name)
+	writeAudit(t, w, "first")
+	writeAudit(t, w, "second")
+	w.file.Close()
+
+	info, _ := os.Stat(name)
+	os.Truncate(name, info.Size()-3)
+	if got, err := decryptFile(t, name); err != ErrTruncatedLog || got != "first\n" {
+		t.Fatalf("unexpected result %q, %v", got, err)
+	}
+
+	w = openEncrypted(t, name)
+	writeAudit(t, w, "third")
+	w.file.Close()
+
+	got, err := decryptFile(t, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "first\nthird\n" {
+		t.Fatalf("unexpected content %q", got)
+	}
+}
+
+func TestEncryptTornHeader(t *testing.T) {
+	dir := encTempDir(t)
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "enc.log")
+	ioutil.WriteFile(name, []byte(encMagic[:5]), 0644)
+
+	w := openEncrypted(t, name)
+	writeAudit(t, w, "first")
+	w.file.Close()
+
+	got, err := decryptFile(t, name)
+	if err != nil || got != "first\n" {
+		t.Fatalf("unexpected result %q, %v", got, err)
+	}
+}
+
+func TestEncryptPlainFileMovedAside(t *testing.T) {
+	dir := encTempDir(t)
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "enc.log")
+	ioutil.WriteFile(name, []byte("plain\n"), 0644)
+
+	w := openEncrypted(t, name)
+	writeAudit(t, w, "first")
+	w.file.Close()
+
+	got, err := decryptFile(t, name)
+	if err != nil || got != "first\n" {
+		t.Fatalf("unexpected result %q, %v", got, err)
+	}
+	aside, _ := filepath.Glob(name + ".plain.*")
+	if len(aside) != 1 {
+		t.Fatalf("plain file not moved aside: %v", aside)
+	}
+	if content, _ := ioutil.ReadFile(aside[0]); string(content) != "plain\n" {
+		t.Fatalf("unexpected plain content %q", content)
+	}
+}
+
+func TestVerifyEncryptedAuditLog(t *testing.T) {
+	dir := encTempDir(t)
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "audit.log")
+	key := []byte("secret")
+
+	w := NewFileWriter()
+	w.SetFileName(name)
+	w.SetAudit(key)
+	if err := w.SetEncryptKey(testEncKey); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Init(); err != nil {
+		t.Fatal(err)
+	}
+	writeAudit(t, w, "first", "second")
+	w.file.Close()
+
+	report, err := VerifyEncryptedAuditLog(key, testEncKey, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Broken || report.Records != 2 {
+		t.Fatalf("unexpected report: %v", report)
+	}
+	if _, err := VerifyEncryptedAuditLog(key, []byte("fedcba9876543210"), name); err == nil {
+		t.Fatal("wrong encryption key accepted")
+	}
+}
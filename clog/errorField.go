@@ -0,0 +1,107 @@
+package clog
+
+import (
+	"fmt"
+)
+
+// maxErrorDepth bounds how far an error chain is followed.
+const maxErrorDepth = 32
+
+// errorInfo is the snapshot of an error taken by Err and NamedError: its
+// message, concrete type, the errors it wraps and, for errors that print
+// more with %+v, that verbose form which usually carries a stack.
+type errorInfo struct {
+	msg     string
+	typ     string
+	verbose string
+	causes  []*errorInfo
+}
+
+func newErrorInfo(err error, depth int) *errorInfo {
+	info := &errorInfo{msg: err.Error(), typ: fmt.Sprintf("%T", err)}
+	if depth >= maxErrorDepth {
+		return info
+	}
+	switch e := err.(type) {
+	case interface{ Unwrap() []error }:
+		for _, cause := range e.Unwrap() {
+			if cause != nil {
+				info.causes = append(info.causes, newErrorInfo(cause, depth+1))
+			}
+		}
+	case interface{ Unwrap() error }:
+		if cause := e.Unwrap(); cause != nil {
+			info.causes = append(info.causes, newErrorInfo(cause, depth+1))
+		}
+	}
+	return info
+}
+
+// writeTypes appends the types of the chain, joined branches in
+// parentheses: "*fs.PathError <- syscall.Errno".
+func (info *errorInfo) writeTypes(b []byte) []byte {
+	b = append(b, info.typ...)
+	switch len(info.causes) {
+	case 0:
+		return b
+	case 1:
+		b = append(b, " <- "...)
+		return info.causes[0].writeTypes(b)
+	}
+	b = append(b, " <- ("...)
+	for i, cause := range info.causes {
+		if i > 0 {
+			b = append(b, ", "...)
+		}
+		b = cause.writeTypes(b)
+	}
+	return append(b, ')')
+}
+
+func (info *errorInfo) MarshalLogObject(enc ObjectEncoder) error {
+	enc.AddString("message", info.msg)
+	enc.AddString("type", info.typ)
+	if len(info.causes) > 0 {
+		if err := enc.AddArray("causes", errorCauses(info.causes)); err != nil {
+			return err
+		}
+	}
+	if info.verbose != "" {
+		enc.AddString("stack", info.verbose)
+	}
+	return nil
+}
+
+type errorCauses []*errorInfo
+
+func (causes errorCauses) MarshalLogArray(enc ArrayEncoder) error {
+	for _, cause := range causes {
+		if err := enc.AppendObject(cause); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Err is NamedError with the key "error". It is not called Error, that
+// name is taken by the ERROR level function.
+func Err(err error) Field {
+	return NamedError("error", err)
+}
+
+// NamedError records the message of err, the chain found through Unwrap
+// including errors.Join branches, their concrete types and the %+v form
+// of err when it differs from the message. The text format shows
+// "message [type <- type]", structured encoders the whole tree.
+func NamedError(key string, err error) Field {
+	if err == nil {
+		return String(key, "<nil>")
+	}
+	info := newErrorInfo(err, 0)
+	if _, ok := err.(fmt.Formatter); ok {
+		if v := fmt.Sprintf("%+v", err); v != info.msg {
+			info.verbose = v
+		}
+	}
+	return Field{key: key, fieldType: errorType, obj: info}
+}
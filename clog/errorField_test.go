@@ -0,0 +1,119 @@
+package clog
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+type joinedError []error
+
+func (e joinedError) Error() string {
+	return "joined"
+}
+
+func (e joinedError) Unwrap() []error {
+	return e
+}
+
+// stackError prints a stack with %+v as errors of pkg/errors do.
+type stackError struct{}
+
+func (stackError) Error() string {
+	return "boom"
+}
+
+func (e stackError) Format(s fmt.State, verb rune) {
+	s.Write([]byte("boom"))
+	if s.Flag('+') {
+		s.Write([]byte("\nmain.run\n\tmain.go:12"))
+	}
+}
+
+// loopError wraps itself forever.
+type loopError struct{}
+
+func (e *loopError) Error() string {
+	return "loop"
+}
+
+func (e *loopError) Unwrap() error {
+	return e
+}
+
+func TestErrorFieldText(t *testing.T) {
+	err := fmt.Errorf("load: %w", &os.PathError{Op: "open", Path: "/x", Err: syscall.ENOENT})
+	text, _ := render("m", Err(err))
+	want := `] m error=load:\ open\ /x:\ no\ such\ file\ or\ directory\ [*fmt.wrapError\ <-\ *fs.PathError\ <-\ syscall.Errno]` + "\n"
+	if !strings.HasSuffix(text, want) {
+		t.Fatalf("got %q", text)
+	}
+
+	joined := joinedError{errors.New("a"), fmt.Errorf("b: %w", errors.New("c"))}
+	text, _ = render("m", NamedError("cause", joined))
+	want = `cause=joined\ [clog.joinedError\ <-\ (*errors.errorString,\ *fmt.wrapError\ <-\ *errors.errorString)]` + "\n"
+	if !strings.HasSuffix(text, want) {
+		t.Fatalf("got %q", text)
+	}
+
+	if f := Err(nil); string(f.WriteValue(nil)) != "<nil>" {
+		t.Fatalf("nil error %q", f.WriteValue(nil))
+	}
+}
+
+func TestErrorFieldJSON(t *testing.T) {
+	joined := joinedError{errors.New("a"), fmt.Errorf("b: %w", errors.New("c"))}
+	want := map[string]interface{}{
+		"message": "joined",
+		"type":    "clog.joinedError",
+		"causes": []interface{}{
+			map[string]interface{}{"message": "a", "type": "*errors.errorString"},
+			map[string]interface{}{
+				"message": "b: c",
+				"type":    "*fmt.wrapError",
+				"causes": []interface{}{
+					map[string]interface{}{"message": "c", "type": "*errors.errorString"},
+				},
+			},
+		},
+	}
+	if got := renderError(t, joined); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v", got)
+	}
+
+	want = map[string]interface{}{
+		"message": "boom",
+		"type":    "clog.stackError",
+		"stack":   "boom\nmain.run\n\tmain.go:12",
+	}
+	if got := renderError(t, stackError{}); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v", got)
+	}
+}
+
+func renderError(t *testing.T, err error) interface{} {
+	_, js := render("m", Err(err))
+	var doc struct {
+		Error interface{} `json:"error"`
+	}
+	if err := json.Unmarshal([]byte(js), &doc); err != nil {
+		t.Fatalf("%v: %s", err, js)
+	}
+	return doc.Error
+}
+
+func TestErrorChainDepth(t *testing.T) {
+	info := newErrorInfo(&loopError{}, 0)
+	depth := 0
+	for ; len(info.causes) > 0; info = info.causes[0] {
+		depth++
+	}
+	if depth != maxErrorDepth {
+		t.Fatalf("followed %d causes", depth)
+	}
+}
@@ -0,0 +1,141 @@
+package clog
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// WriterOp is the writer operation which failed.
+type WriterOp int
+
+const (
+	OpWrite WriterOp = iota
+	OpFlush
+	OpRotate
+	OpDelete
+	OpClose
+	numWriterOps
+)
+
+var writerOpNames = [numWriterOps]string{"write", "flush", "rotate", "delete", "close"}
+
+func (op WriterOp) String() string {
+	if op < 0 || op >= numWriterOps {
+		return "op(" + fmt.Sprint(int(op)) + ")"
+	}
+	return writerOpNames[op]
+}
+
+// WriterError is a failure of a writer handed to the ErrorHandler.
+type WriterError struct {
+	Writer Writer
+	Op     WriterOp
+	File   string // the file concerned, if known
+	Err    error
+}
+
+func (e *WriterError) Error() string {
+	s := fmt.Sprintf("clog: %s %T", e.Op, e.Writer)
+	if e.File != "" {
+		s += " " + e.File
+	}
+	return s + ": " + e.Err.Error()
+}
+
+func (e *WriterError) Unwrap() error {
+	return e.Err
+}
+
+// ErrorHandler receives the failures of the writers. It is called from
+// the writer goroutine, or the log call of a synchronous logger, and must
+// not log through the same logger.
+type ErrorHandler func(err *WriterError)
+
+// StderrErrorHandler returns the default handler, writing to out at most
+// one error of each operation per second and how many were left out.
+func StderrErrorHandler(out io.Writer) ErrorHandler {
+	var (
+		mu         sync.Mutex
+		last       [numWriterOps]time.Time
+		suppressed [numWriterOps]int
+	)
+	return func(err *WriterError) {
+		op := err.Op
+		if op < 0 || op >= numWriterOps {
+			op = OpWrite
+		}
+		now := time.Now()
+		mu.Lock()
+		if now.Sub(last[op]) < time.Second {
+			suppressed[op]++
+			mu.Unlock()
+			return
+		}
+		last[op] = now
+		n := suppressed[op]
+		suppressed[op] = 0
+		mu.Unlock()
+		if n > 0 {
+			fmt.Fprintf(out, "%v (%d more %s errors suppressed)\n", err, n, op)
+		} else {
+			fmt.Fprintln(out, err)
+		}
+	}
+}
+
+// SetErrorHandler sets the handler of writer failures, nil restores the
+// default one writing to stderr.
+func (l *Logger) SetErrorHandler(h ErrorHandler) {
+	if h == nil {
+		h = StderrErrorHandler(os.Stderr)
+	}
+	l.mu.Lock()
+	l.errorHandler = h
+	l.mu.Unlock()
+}
+
+// ErrorCount returns how many times op failed on the writers of l.
+func (l *Logger) ErrorCount(op WriterOp) uint64 {
+	if op < 0 || op >= numWriterOps {
+		return 0
+	}
+	return atomic.LoadUint64(&l.errorCounts[op])
+}
+
+// handleError counts err and hands it to the error handler, l.mu held.
+func (l *Logger) handleError(w Writer, op WriterOp, err error) {
+	we, ok := err.(*WriterError)
+	if !ok {
+		we = &WriterError{Writer: w, Op: op, Err: err}
+		var (
+			pe *os.PathError
+			le *os.LinkError
+		)
+		switch {
+		case errors.As(err, &pe):
+			we.File = pe.Path
+		case errors.As(err, &le):
+			we.File = le.Old
+		}
+	}
+	if we.Writer == nil {
+		we.Writer = w
+	}
+	if we.Op >= 0 && we.Op < numWriterOps {
+		atomic.AddUint64(&l.errorCounts[we.Op], 1)
+	}
+	l.errorHandler(we)
+}
+
+func SetErrorHandler(h ErrorHandler) {
+	logger_default.SetErrorHandler(h)
+}
+
+func ErrorCount(op WriterOp) uint64 {
+	return logger_default.ErrorCount(op)
+}
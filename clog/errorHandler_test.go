@@ -0,0 +1,97 @@
+package clog
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+)
+
+type failingWriter struct{}
+
+func (failingWriter) Init() error { return nil }
+
+func (failingWriter) Write(enc *textEncoder) error {
+	return &os.PathError{Op: "write", Path: "/var/log/app.log", Err: os.ErrPermission}
+}
+
+func TestErrorHandler(t *testing.T) {
+	l := NewLogger()
+	defer l.Close()
+	var got []*WriterError
+	l.SetErrorHandler(func(err *WriterError) {
+		got = append(got, err)
+	})
+	l.Register(failingWriter{})
+	l.Info("one")
+	l.Info("two")
+	l.Sync()
+	if len(got) != 2 || l.ErrorCount(OpWrite) != 2 {
+		t.Fatalf("got %d errors, counted %d", len(got), l.ErrorCount(OpWrite))
+	}
+	if got[0].Op != OpWrite || got[0].File != "/var/log/app.log" || !os.IsPermission(got[0].Err) {
+		t.Fatalf("unexpected error %v", got[0])
+	}
+
+	var out bytes.Buffer
+	h := StderrErrorHandler(&out)
+	for i := 0; i < 3; i++ {
+		h(got[0])
+	}
+	h(&WriterError{Writer: failingWriter{}, Op: OpFlush, Err: os.ErrClosed})
+	if lines := strings.Count(out.String(), "\n"); lines != 2 {
+		t.Fatalf("want one line per operation, got %q", out.String())
+	}
+}
+
+type initFailingWriter struct{ failingWriter }
+
+func (initFailingWriter) Init() error {
+	return &os.PathError{Op: "open", Path: "/var/log/app.log", Err: os.ErrPermission}
+}
+
+func TestRegisterInitError(t *testing.T) {
+	l, o := NewObservedLogger(TRACE)
+	defer l.Close()
+	var got []*WriterError
+	l.SetErrorHandler(func(err *WriterError) {
+		got = append(got, err)
+	})
+	if err := l.RegisterErr(initFailingWriter{}); !os.IsPermission(err) {
+		t.Fatalf("RegisterErr returned %v", err)
+	}
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("Register did not panic")
+			}
+		}()
+		l.Register(initFailingWriter{})
+	}()
+	l.Info("one")
+	l.Sync()
+	if len(got) != 0 || len(l.Stats().Writers) != 1 || o.Len() != 1 {
+		t.Fatalf("failed writer registered or reported: %v, %+v", got, l.Stats().Writers)
+	}
+}
+
+type renameFailingWriter struct{ failingWriter }
+
+func (renameFailingWriter) Write(enc *textEncoder) error {
+	return &os.LinkError{Op: "rename", Old: "/var/log/app.log", New: "/var/log/app.log.1", Err: os.ErrPermission}
+}
+
+func TestErrorHandlerLinkErrorFile(t *testing.T) {
+	l := NewLogger()
+	defer l.Close()
+	var got []*WriterError
+	l.SetErrorHandler(func(err *WriterError) {
+		got = append(got, err)
+	})
+	l.Register(renameFailingWriter{})
+	l.Info("one")
+	l.Sync()
+	if len(got) != 1 || got[0].File != "/var/log/app.log" {
+		t.Fatalf("unexpected errors %v", got)
+	}
+}
@@ -0,0 +1,193 @@
+package clog
+
+import (
+	"fmt"
+	"strconv"
+	"unicode/utf8"
+)
+
+// needsEscape reports whether appendEscaped would change s.
+func needsEscape(s string, sep byte) bool {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c >= utf8.RuneSelf {
+			if r, _ := utf8.DecodeRuneInString(s[i:]); escapedRune(r) {
+				return true
+			}
+			continue
+		}
+		if escapedByte(c, sep) {
+			return true
+		}
+	}
+	return false
+}
+
+// escapeIndex is needsEscape for bytes, returning where the first change
+// is or -1.
+func escapeIndex(b []byte, sep byte) int {
+	for i := 0; i < len(b); i++ {
+		c := b[i]
+		if c >= utf8.RuneSelf {
+			if r, _ := utf8.DecodeRune(b[i:]); escapedRune(r) {
+				return i
+			}
+			continue
+		}
+		if escapedByte(c, sep) {
+			return i
+		}
+	}
+	return -1
+}
+
+// escapedByte reports whether the ASCII byte c is escaped: controls, the
+// backslash starting escapes and sep, the separator of the record if not 0.
+func escapedByte(c byte, sep byte) bool {
+	return c < 0x20 || c == 0x7f || c == '\\' || (sep != 0 && c == sep)
+}
+
+// escapedRune reports whether r is a C1 control or a unicode line or
+// paragraph separator, which some viewers break lines on.
+func escapedRune(r rune) bool {
+	return (r >= 0x80 && r <= 0x9f) || r == 0x2028 || r == 0x2029
+}
+
+// appendEscapedRune appends the escape of r, an escapedByte or an
+// escapedRune.
+func appendEscapedRune(b []byte, r rune) []byte {
+	switch r {
+	case '\n':
+		return append(b, '\\', 'n')
+	case '\r':
+		return append(b, '\\', 'r')
+	case '\t':
+		return append(b, '\\', 't')
+	}
+	if r >= 0x20 && r < 0x7f {
+		// the backslash or the separator
+		return append(b, '\\', byte(r))
+	}
+	if r < utf8.RuneSelf {
+		return append(b, '\\', 'x', hexDigits[r>>4], hexDigits[r&0xf])
+	}
+	return append(b, '\\', 'u', hexDigits[r>>12&0xf], hexDigits[r>>8&0xf],
+		hexDigits[r>>4&0xf], hexDigits[r&0xf])
+}
+
+// appendEscaped appends s so it cannot start a new line: control
+// characters become \n, \r, \t or \xNN, C1 controls and unicode line
+// separators \uNNNN and '\' becomes \\ so escapes can be told from the
+// text. A non zero sep, the separator of the record, is escaped too: '|'
+// for PUBLIC and keys/values records split on "||", ' ' for the fields of
+// the others.
+func appendEscaped(b []byte, s string, sep byte) []byte {
+	start := 0
+	for i := 0; i < len(s); {
+		c := s[i]
+		if c >= utf8.RuneSelf {
+			r, size := utf8.DecodeRuneInString(s[i:])
+			if escapedRune(r) {
+				b = appendEscapedRune(append(b, s[start:i]...), r)
+				start = i + size
+			}
+			i += size
+			continue
+		}
+		if escapedByte(c, sep) {
+			b = appendEscapedRune(append(b, s[start:i]...), rune(c))
+			start = i + 1
+		}
+		i++
+	}
+	return append(b, s[start:]...)
+}
+
+// escapeTail escapes what was appended to b after start. It scans b in
+// place and only copies the bytes from the first change on.
+func escapeTail(b []byte, start int, sep byte) []byte {
+	i := escapeIndex(b[start:], sep)
+	if i < 0 {
+		return b
+	}
+	i += start
+	tail := append([]byte(nil), b[i:]...)
+	b = b[:i]
+	from := 0
+	for j := 0; j < len(tail); {
+		c := tail[j]
+		if c >= utf8.RuneSelf {
+			r, size := utf8.DecodeRune(tail[j:])
+			if escapedRune(r) {
+				b = appendEscapedRune(append(b, tail[from:j]...), r)
+				from = j + size
+			}
+			j += size
+			continue
+		}
+		if escapedByte(c, sep) {
+			b = appendEscapedRune(append(b, tail[from:j]...), rune(c))
+			from = j + 1
+		}
+		j++
+	}
+	return append(b, tail[from:]...)
+}
+
+func escapeString(s string, sep byte) string {
+	if !needsEscape(s, sep) {
+		return s
+	}
+	return string(appendEscaped(make([]byte, 0, len(s)+8), s, sep))
+}
+
+// escapeArgs wraps the format arguments of a PUBLIC record so that they
+// print escaped, '|' included, and only the format can place the "||"
+// separator.
+func escapeArgs(args []interface{}) []interface{} {
+	escaped := make([]interface{}, len(args))
+	for i, arg := range args {
+		escaped[i] = escapedArg{arg}
+	}
+	return escaped
+}
+
+// escapedArg prints its value with the verb and flags it is given, then
+// escapes the result.
+type escapedArg struct {
+	v interface{}
+}
+
+func (a escapedArg) Format(f fmt.State, verb rune) {
+	format := []byte{'%'}
+	for _, flag := range "+-# 0" {
+		if f.Flag(int(flag)) {
+			format = append(format, byte(flag))
+		}
+	}
+	if w, ok := f.Width(); ok {
+		format = strconv.AppendInt(format, int64(w), 10)
+	}
+	if p, ok := f.Precision(); ok {
+		format = append(format, '.')
+		format = strconv.AppendInt(format, int64(p), 10)
+	}
+	format = append(format, string(verb)...)
+	fmt.Fprint(f, escapeString(fmt.Sprintf(string(format), a.v), '|'))
+}
+
+// Multiline logs trusted text as is, newlines included, where other
+// values are escaped.
+func Multiline(key string, val string) Field {
+	return Field{key: key, fieldType: rawType, str: val}
+}
+
+// SetEscaping turns the escaping of control characters and of the "||"
+// separator in text records on (the default) or off.
+func (l *Logger) SetEscaping(on bool) {
+	l.noEscape = !on
+}
+
+func SetEscaping(on bool) {
+	logger_default.SetEscaping(on)
+}
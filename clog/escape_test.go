@@ -0,0 +1,116 @@
+package clog
+
+import (
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestEscapeTail(t *testing.T) {
+	for _, c := range []struct {
+		in  string
+		sep byte
+		out string
+	}{
+		{"plain", 0, "plain"},
+		{"a\nb", 0, `a\nb`},
+		{`a\nb`, 0, `a\\nb`},
+		{"tab\there\r", 0, `tab\there\r`},
+		{"bell\x07 del\x7f", 0, `bell\x07 del\x7f`},
+		{"nel\u0085 ls\u2028 ps\u2029", 0, `nel\u0085 ls\u2028 ps\u2029`},
+		{"héllo 世界", 0, "héllo 世界"},
+		{"a|b", 0, "a|b"},
+		{"a|b", '|', `a\|b`},
+		{"\xff\n", 0, "\xff\\n"},
+	} {
+		b := escapeTail([]byte("head\n|"+c.in), len("head\n|"), c.sep)
+		if got := string(b); got != "head\n|"+c.out {
+			t.Errorf("escapeTail(%q, %v) = %q, want %q", c.in, c.sep, got, "head\n|"+c.out)
+		}
+		if got := escapeString(c.in, c.sep); got != c.out {
+			t.Errorf("escapeString(%q, %v) = %q, want %q", c.in, c.sep, got, c.out)
+		}
+	}
+}
+
+func TestEscapeTailNoCopy(t *testing.T) {
+	b := []byte("prefix\nsome ordinary value")
+	allocs := testing.AllocsPerRun(100, func() {
+		b = escapeTail(b, len("prefix\n"), '|')
+	})
+	if allocs != 0 {
+		t.Errorf("escapeTail allocated %v times without escaping", allocs)
+	}
+}
+
+func TestEscapeBackslashInRecord(t *testing.T) {
+	newline, _ := render("m", String("k", "a\nb"))
+	literal, _ := render("m", String("k", `a\nb`))
+	if newline == literal {
+		t.Fatalf("newline and backslash n both written as %q", newline)
+	}
+	if !strings.HasSuffix(literal, ` k=a\\nb`+"\n") {
+		t.Errorf("text %q", literal)
+	}
+}
+
+// lineWriter keeps the text records it is given.
+type lineWriter struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (w *lineWriter) Init() error { return nil }
+
+func (w *lineWriter) Write(enc *textEncoder) error {
+	w.mu.Lock()
+	w.lines = append(w.lines, string(enc.bytes))
+	w.mu.Unlock()
+	return nil
+}
+
+// logged returns the records after the caller, "[file:line] ".
+func (w *lineWriter) logged() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	out := make([]string, len(w.lines))
+	for i, line := range w.lines {
+		out[i] = line[strings.Index(line, ".go:")+4:]
+		out[i] = out[i][strings.Index(out[i], "] ")+2:]
+	}
+	return out
+}
+
+func TestEscapeOnceInRecords(t *testing.T) {
+	l := NewLogger()
+	l.SetLevel(TRACE)
+	w := &lineWriter{}
+	l.Register(w)
+	lc := &LoggerContext{lg: l, logID: "7", detail: map[string]interface{}{}, level: levelUnset}
+
+	l.InfoSort([]string{"path", "a|b"}, []interface{}{`C:\dir` + "\nX", "v||w"})
+	lc.LogInfo([]string{"path"}, []interface{}{`C:\dir` + "\nX"})
+	l.Public("user=%s||n=%d", "a||forged=1", 2)
+	l.Public("note=%v", struct{ S string }{"x\ny|z"})
+	l.Public(`dir=C:\tmp`)
+	l.deliverRecordToWriterHight(INFO, `m\n`, String("k", "a b=c"), String("x y", `\`))
+	l.sync()
+
+	want := []string{
+		`=> path=C:\\dir\nX||a\|b=v\|\|w` + "\n",
+		`>>path= C:\\dir\nX||logID= 7` + "\n",
+		`user=a\|\|forged=1||n=2` + "\n",
+		`note={x\ny\|z}` + "\n",
+		`dir=C:\\tmp` + "\n",
+		`m\\n k=a\ b=c x\ y=\\` + "\n",
+	}
+	got := w.logged()
+	if len(got) != len(want) {
+		t.Fatalf("got %d records: %q", len(got), got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("record %d: got %q, want %q", i, got[i], want[i])
+		}
+	}
+}
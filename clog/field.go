@@ -22,6 +22,15 @@ const (
 	stringType
 	objectType
 	stringerType
+	stackType
+	errorType
+	timeType
+	durationType
+	arrayType
+	objectMarshalerType
+	namespaceType
+	secretType
+	rawType
 )
 
 type Field struct {
@@ -32,32 +41,114 @@ type Field struct {
 	obj       interface{}
 }
 
+func (f Field) Key() string {
+	return f.key
+}
+
+// Value returns the value as written in text records.
+func (f Field) Value() string {
+	return string(f.WriteValue(nil))
+}
+
 func (f *Field) WriteValue(b []byte) []byte {
+	b, _ = f.appendValue(b)
+	return b
+}
+
+// appendValue is WriteValue which also returns the error of a marshaler,
+// written after the value as keyError like AddTo does.
+func (f *Field) appendValue(b []byte) ([]byte, error) {
 	switch f.fieldType {
 	case boolType:
-		return strconv.AppendBool(b, f.ival == 1)
-	case stringType:
-		return append(b, f.str...)
+		return strconv.AppendBool(b, f.ival == 1), nil
+	case stringType, rawType:
+		return append(b, f.str...), nil
 	case intType:
-		return strconv.AppendInt(b, int64(f.ival), 10)
+		return strconv.AppendInt(b, int64(f.ival), 10), nil
 	case int64Type:
-		return strconv.AppendInt(b, f.ival, 10)
+		return strconv.AppendInt(b, f.ival, 10), nil
 	case floatType:
 		return strconv.AppendFloat(b, math.Float64frombits(uint64(f.ival)),
-			'f', -1, 64)
+			'f', -1, 64), nil
 	case uintType:
-		return strconv.AppendUint(b, uint64(f.ival), 10)
+		return strconv.AppendUint(b, uint64(f.ival), 10), nil
 	case objectType:
-		return append(b, fmt.Sprintf("%+v", f.obj)...)
+		return append(b, fmt.Sprintf("%+v", f.obj)...), nil
 	case stringerType:
-		return append(b, f.obj.(fmt.Stringer).String()...)
+		return append(b, f.obj.(fmt.Stringer).String()...), nil
+	case stackType:
+		b = append(b, '\n')
+		return append(b, f.str...), nil
+	case errorType:
+		info := f.obj.(*errorInfo)
+		b = append(b, info.msg...)
+		b = append(b, " ["...)
+		b = info.writeTypes(b)
+		return append(b, ']'), nil
 	case uintptrType:
 		b = append(b, "0x"...)
-		return strconv.AppendUint(b, uint64(f.ival), 16)
+		return strconv.AppendUint(b, uint64(f.ival), 16), nil
+	case timeType:
+		return f.obj.(time.Time).AppendFormat(b, time.RFC3339Nano), nil
+	case durationType:
+		return append(b, time.Duration(f.ival).String()...), nil
+	case arrayType:
+		enc := getTextObjectEncoder(b)
+		err := enc.AppendArray(f.obj.(ArrayMarshaler))
+		return putTextObjectEncoder(enc), err
+	case objectMarshalerType:
+		enc := getTextObjectEncoder(b)
+		err := enc.AppendObject(f.obj.(ObjectMarshaler))
+		return putTextObjectEncoder(enc), err
+	case namespaceType:
+		return b, nil
+	case secretType:
+		return append(b, redactMask...), nil
 	default:
 
 	}
-	return nil
+	return nil, nil
+}
+
+// AddTo writes f into enc, which is how fields nest inside Dict and how the
+// JSON encoding writes them.
+func (f Field) AddTo(enc ObjectEncoder) {
+	var err error
+	switch f.fieldType {
+	case boolType:
+		enc.AddBool(f.key, f.ival == 1)
+	case floatType:
+		enc.AddFloat64(f.key, math.Float64frombits(uint64(f.ival)))
+	case intType, int64Type:
+		enc.AddInt64(f.key, f.ival)
+	case uintType, uint64Type:
+		enc.AddUint64(f.key, uint64(f.ival))
+	case stringType, stackType, rawType:
+		enc.AddString(f.key, f.str)
+	case stringerType:
+		enc.AddString(f.key, f.obj.(fmt.Stringer).String())
+	case uintptrType:
+		enc.AddString(f.key, string(f.WriteValue(nil)))
+	case objectType:
+		err = enc.AddReflected(f.key, f.obj)
+	case errorType:
+		err = enc.AddObject(f.key, f.obj.(*errorInfo))
+	case timeType:
+		enc.AddTime(f.key, f.obj.(time.Time))
+	case durationType:
+		enc.AddDuration(f.key, time.Duration(f.ival))
+	case arrayType:
+		err = enc.AddArray(f.key, f.obj.(ArrayMarshaler))
+	case objectMarshalerType:
+		err = enc.AddObject(f.key, f.obj.(ObjectMarshaler))
+	case namespaceType:
+		enc.OpenNamespace(f.key)
+	case secretType:
+		enc.AddString(f.key, redactMask)
+	}
+	if err != nil {
+		enc.AddString(f.key+"Error", err.Error())
+	}
 }
 
 //Base64 转换
@@ -108,10 +199,122 @@ func Stringer(key string, val fmt.Stringer) Field {
 	return Field{key: key, fieldType: stringerType, obj: val}
 }
 
-func Duration(key string, val time.Duration) Field {
+func Int32(key string, val int32) Field {
 	return Int64(key, int64(val))
 }
 
+func Uint32(key string, val uint32) Field {
+	return Uint64(key, uint64(val))
+}
+
+func Float32(key string, val float32) Field {
+	return Float64(key, float64(val))
+}
+
+// Duration is written as 1.5s rather than as nanoseconds.
+func Duration(key string, val time.Duration) Field {
+	return Field{key: key, fieldType: durationType, ival: int64(val)}
+}
+
+func Time(key string, val time.Time) Field {
+	return Field{key: key, fieldType: timeType, obj: val}
+}
+
+// Object writes val through its MarshalLogObject or MarshalLogArray method
+// when it has one, otherwise with %+v, or encoding/json in the JSON format.
 func Object(key string, val interface{}) Field {
+	switch v := val.(type) {
+	case ObjectMarshaler:
+		return Field{key: key, fieldType: objectMarshalerType, obj: v}
+	case ArrayMarshaler:
+		return Array(key, v)
+	}
+	return Field{key: key, fieldType: objectType, obj: val}
+}
+
+// Dict groups fields under key.
+func Dict(key string, fields ...Field) Field {
+	return Field{key: key, fieldType: objectMarshalerType, obj: fieldsObject(fields)}
+}
+
+// Namespace nests the fields after it under key: {"key":{...}} in JSON,
+// key.name=value in text.
+func Namespace(key string) Field {
+	return Field{key: key, fieldType: namespaceType}
+}
+
+// Any picks the Field constructor matching the type of val, falling back
+// to Object.
+func Any(key string, val interface{}) Field {
+	switch v := val.(type) {
+	case ObjectMarshaler:
+		return Field{key: key, fieldType: objectMarshalerType, obj: v}
+	case ArrayMarshaler:
+		return Array(key, v)
+	case bool:
+		return Bool(key, v)
+	case []bool:
+		return Bools(key, v)
+	case float64:
+		return Float64(key, v)
+	case []float64:
+		return Float64s(key, v)
+	case float32:
+		return Float32(key, v)
+	case int:
+		return Int(key, v)
+	case []int:
+		return Ints(key, v)
+	case int64:
+		return Int64(key, v)
+	case []int64:
+		return Int64s(key, v)
+	case int32:
+		return Int32(key, v)
+	case int16:
+		return Int64(key, int64(v))
+	case int8:
+		return Int64(key, int64(v))
+	case uint:
+		return Uint(key, v)
+	case uint64:
+		return Uint64(key, v)
+	case []uint64:
+		return Uint64s(key, v)
+	case uint32:
+		return Uint32(key, v)
+	case uint16:
+		return Uint64(key, uint64(v))
+	case uint8:
+		return Uint64(key, uint64(v))
+	case uintptr:
+		return Uintptr(key, v)
+	case string:
+		return String(key, v)
+	case []string:
+		return Strings(key, v)
+	case []byte:
+		return Base64(key, v)
+	case time.Time:
+		return Time(key, v)
+	case []time.Time:
+		return Times(key, v)
+	case time.Duration:
+		return Duration(key, v)
+	case []time.Duration:
+		return Durations(key, v)
+	case error:
+		return NamedError(key, v)
+	case []error:
+		return Errs(key, v)
+	case fmt.Stringer:
+		return Stringer(key, v)
+	}
 	return Field{key: key, fieldType: objectType, obj: val}
 }
+
+// Stack captures the stack of the current goroutine, leaving out the
+// frames of clog itself.
+func Stack(key string) Field {
+	return Field{key: key, fieldType: stackType, str: takeStacktrace()}
+}
@@ -3,17 +3,22 @@ package clog
 import (
 	"bufio"
 	"bytes"
+	"crypto/cipher"
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path"
 	"path/filepath"
+	"strings"
 	"time"
 )
 
 var pathVariableTable map[byte]func(time *time.Time) int
 
 type FileWriter struct {
+	writeCount
+
 	logLevelFloor int
 	logLevelCeil  int
 	filename      string
@@ -24,14 +29,87 @@ type FileWriter struct {
 	variables     []interface{}
 	deleteCycle   uint64
 	root          string
+	audit         *auditChain
+	key           []byte
+	aead          cipher.AEAD
+	rotations     uint64
+	deleted       uint64
 }
 
 func NewFileWriter() *FileWriter {
-	return &FileWriter{}
+	return &FileWriter{logLevelCeil: PUBLIC}
 }
 
 func (w *FileWriter) Init() error {
-	return w.CreateLogFile()
+	// an encrypted file is set right before the audit chain reads it
+	if err := w.CreateLogFile(); err != nil {
+		return err
+	}
+	if w.audit == nil {
+		return nil
+	}
+	torn, err := w.resumeAudit()
+	if err != nil {
+		return err
+	}
+	if torn {
+		// end the cut record so the next one starts on its own line
+		return w.fileBufWriter.WriteByte('\n')
+	}
+	return nil
+}
+
+// resumeAudit continues the audit chain of the current file, or of the
+// head saved at the last rotation when the file holds no record. A record
+// cut by a crash is truncated, torn tells when it is inside an encrypted
+// frame and can only be ended with a newline.
+func (w *FileWriter) resumeAudit() (torn bool, err error) {
+	f, err := os.Open(w.filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, w.resumeAuditHead()
+		}
+		return false, err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	var size int64
+	if w.aead != nil {
+		var plain bytes.Buffer
+		if err := DecryptLog(&plain, f, w.key); err != nil && err != ErrTruncatedLog {
+			return false, err
+		}
+		r, size = &plain, int64(plain.Len())
+	} else {
+		info, err := f.Stat()
+		if err != nil {
+			return false, err
+		}
+		size = info.Size()
+	}
+	end, err := w.audit.resume(r)
+	if err != nil {
+		return false, fmt.Errorf("resume audit chain of %s: %v", w.filename, err)
+	}
+	if end < size {
+		if w.aead != nil {
+			torn = true
+		} else if err := os.Truncate(w.filename, end); err != nil {
+			return false, err
+		}
+	}
+	if end == 0 {
+		return torn, w.resumeAuditHead()
+	}
+	return torn, nil
+}
+
+func (w *FileWriter) resumeAuditHead() error {
+	if err := w.audit.loadHead(w.filename + auditHeadSuffix); err != nil {
+		return fmt.Errorf("resume audit chain of %s: %v", w.filename, err)
+	}
+	return nil
 }
 
 func (w *FileWriter) SetFileName(filename string) {
@@ -43,7 +121,7 @@ func (w *FileWriter) SetLogLevelFloor(floor int) {
 }
 
 func (w *FileWriter) SetLogLevelCeil(ceil int) {
-	w.logLevelFloor = ceil
+	w.logLevelCeil = ceil
 }
 
 func (w *FileWriter) SetLogDeleteCycle(dc uint64) {
@@ -98,8 +176,12 @@ func (w *FileWriter) SetPathPattern(pattern string) error {
 	return nil
 }
 
+func (w *FileWriter) accepts(level int) bool {
+	return levelBetween(level, w.logLevelFloor, w.logLevelCeil)
+}
+
 func (w *FileWriter) Write(enc *textEncoder) error {
-	if enc.level < w.logLevelFloor || enc.level > w.logLevelFloor {
+	if !w.accepts(enc.level) {
 		return nil
 	}
 	if w.fileBufWriter == nil {
@@ -107,8 +189,15 @@ func (w *FileWriter) Write(enc *textEncoder) error {
 	}
 	var err error
 
-	_, err = w.fileBufWriter.Write(enc.bytes)
-	return err
+	line := enc.bytes
+	if w.audit != nil {
+		line = w.audit.seal(enc.bytes)
+	}
+	if _, err = w.fileBufWriter.Write(line); err != nil {
+		return err
+	}
+	w.count(1, len(line))
+	return nil
 }
 
 func (w *FileWriter) CreateLogFile() error {
@@ -118,6 +207,14 @@ func (w *FileWriter) CreateLogFile() error {
 		}
 	}
 
+	var enc *encryptWriter
+	if w.aead != nil {
+		var err error
+		if enc, err = openEncryptWriter(w.filename, w.aead); err != nil {
+			return err
+		}
+	}
+
 	if file, err := os.OpenFile(w.filename, os.O_RDWR|os.O_CREATE|os.
 		O_APPEND, 0644); err != nil {
 		return err
@@ -125,7 +222,13 @@ func (w *FileWriter) CreateLogFile() error {
 		w.file = file
 	}
 
-	if w.fileBufWriter = bufio.NewWriterSize(w.file,
+	var out io.Writer = w.file
+	if enc != nil {
+		enc.w = w.file
+		out = enc
+	}
+
+	if w.fileBufWriter = bufio.NewWriterSize(out,
 		8192); w.fileBufWriter == nil {
 		return errors.New("new fileBufWriter failed.")
 	}
@@ -155,6 +258,14 @@ func (w *FileWriter) Rotate() error {
 	}
 
 	if w.fileBufWriter != nil {
+		if err := w.fileBufWriter.Flush(); err != nil {
+			return err
+		}
+		if w.audit != nil {
+			if err := w.audit.saveHead(w.filename + auditHeadSuffix); err != nil {
+				return err
+			}
+		}
 		// 将文件以pattern形式改名并关闭
 		filePath := fmt.Sprintf(w.pathFmt, oldVariables...)
 
@@ -167,17 +278,36 @@ func (w *FileWriter) Rotate() error {
 		}
 	}
 
-	return w.CreateLogFile()
+	if err := w.CreateLogFile(); err != nil {
+		return err
+	}
+	w.rotations++
+	return nil
+}
+
+// Rotations returns how many times the file was rotated.
+func (w *FileWriter) Rotations() uint64 {
+	return w.rotations
+}
+
+// Deleted returns how many expired files were removed.
+func (w *FileWriter) Deleted() uint64 {
+	return w.deleted
 }
 
 // Delete expired log file
 func (w *FileWriter) Delete() error {
 	nowTime := time.Now().Unix() // current time
+	var first error
 	err := filepath.Walk(w.root, func(path string, f os.FileInfo,
 		err error) error {
 		if f == nil {
 			return err
 		}
+		if strings.HasSuffix(path, auditHeadSuffix) {
+			// the head carries the audit chain over to the next file
+			return nil
+		}
 		fileTime := f.ModTime().Unix()
 		/*
 			fmt.Println(file_time)
@@ -186,9 +316,12 @@ func (w *FileWriter) Delete() error {
 			fmt.Println(now_time - file_time)
 		*/
 		if (nowTime - fileTime) > int64(w.deleteCycle) { //判断文件是否超过7天
-			fmt.Printf("Delete file %v !\r\n", path)
 			if err = os.RemoveAll(path); err != nil {
-				fmt.Println(err)
+				if first == nil {
+					first = err
+				}
+			} else {
+				w.deleted++
 			}
 
 		} //else {
@@ -197,9 +330,10 @@ func (w *FileWriter) Delete() error {
 		return nil
 	})
 	if err != nil {
-		fmt.Printf("filepath.Walk() returned %v\r\n", err)
+		return err
 	}
-	return nil
+	// a file that can't be removed doesn't stop the others
+	return first
 }
 
 func (w *FileWriter) Flush() error {
@@ -209,6 +343,19 @@ func (w *FileWriter) Flush() error {
 	return nil
 }
 
+// Close flushes and closes the current file.
+func (w *FileWriter) Close() error {
+	if w.file == nil {
+		return nil
+	}
+	err := w.Flush()
+	if cerr := w.file.Close(); err == nil {
+		err = cerr
+	}
+	w.file, w.fileBufWriter = nil, nil
+	return err
+}
+
 func getYear(now *time.Time) int {
 	return now.Year()
 }
@@ -0,0 +1,77 @@
+package clog
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFileWriterLevelRange(t *testing.T) {
+	dir, err := ioutil.TempDir("", "clog-file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "service.log.wf")
+
+	w := NewFileWriter()
+	w.SetFileName(name)
+	w.SetLogLevelFloor(WARNING)
+	w.SetLogLevelCeil(FATAL)
+	if err := w.Init(); err != nil {
+		t.Fatal(err)
+	}
+	for _, lvl := range []int{DEBUG, INFO, WARNING, ERROR, PANIC, FATAL, PUBLIC} {
+		enc := &textEncoder{bytes: []byte(LEVEL_FLAGS[lvl] + "\n"), level: lvl}
+		if err := w.Write(enc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	w.file.Close()
+
+	b, err := ioutil.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(b), "WARN\nERROR\nPANIC\nFATAL\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestFileWriterDefaultsToEveryLevel(t *testing.T) {
+	dir, err := ioutil.TempDir("", "clog-file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "service.log")
+
+	w := NewFileWriter()
+	w.SetFileName(name)
+	if err := w.Init(); err != nil {
+		t.Fatal(err)
+	}
+	for lvl := range LEVEL_FLAGS {
+		enc := &textEncoder{bytes: []byte(LEVEL_FLAGS[lvl] + "\n"), level: lvl}
+		if err := w.Write(enc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	w.file.Close()
+
+	b, err := ioutil.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(string(b), "\n"); n != len(LEVEL_FLAGS) {
+		t.Errorf("got %d lines, want %d:\n%s", n, len(LEVEL_FLAGS), b)
+	}
+}
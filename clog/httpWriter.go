@@ -0,0 +1,481 @@
+package clog
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const http_batch_cnt_default = 500
+const http_pending_batches_max = 10
+
+// PayloadBuilder turns a batch of records into the body of a push request.
+// labels holds the static labels of the writer, each record adds its level.
+type PayloadBuilder interface {
+	ContentType() string
+	Build(b []byte, batch []HTTPEntry, labels map[string]string) ([]byte, error)
+}
+
+// HTTPEntry is a record waiting in a batch along with its line as encoded
+// for the other writers.
+type HTTPEntry struct {
+	Record Record
+	Line   []byte
+	limits Limits
+}
+
+// Doc returns the record as a JSON object holding the labels too.
+func (e *HTTPEntry) Doc(b []byte, labels map[string]string) []byte {
+	enc := &textEncoder{bytes: b, limits: e.limits}
+	e.Record.JSON(enc)
+	b = enc.bytes[:len(enc.bytes)-2] // "}\n"
+	for _, k := range sortedKeys(labels) {
+		b = append(b, ',')
+		b = appendJSONString(b, k)
+		b = append(b, ':')
+		b = appendJSONString(b, labels[k])
+	}
+	return append(b, '}')
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// LokiPayload builds a Loki push request, one stream per level.
+type LokiPayload struct{}
+
+func (LokiPayload) ContentType() string {
+	return "application/json"
+}
+
+func (LokiPayload) Build(b []byte, batch []HTTPEntry, labels map[string]string) ([]byte, error) {
+	var levels []int
+	streams := make(map[int][]int)
+	for i := range batch {
+		lvl := batch[i].Record.level
+		if _, ok := streams[lvl]; !ok {
+			levels = append(levels, lvl)
+		}
+		streams[lvl] = append(streams[lvl], i)
+	}
+	keys := sortedKeys(labels)
+	b = append(b, `{"streams":[`...)
+	for n, lvl := range levels {
+		if n > 0 {
+			b = append(b, ',')
+		}
+		b = append(b, `{"stream":{"level":`...)
+		b = appendJSONString(b, strings.ToLower(LEVEL_FLAGS[lvl]))
+		for _, k := range keys {
+			if k == "level" {
+				continue
+			}
+			b = append(b, ',')
+			b = appendJSONString(b, k)
+			b = append(b, ':')
+			b = appendJSONString(b, labels[k])
+		}
+		b = append(b, `},"values":[`...)
+		for j, i := range streams[lvl] {
+			if j > 0 {
+				b = append(b, ',')
+			}
+			b = append(b, `["`...)
+			b = strconv.AppendInt(b, batch[i].Record.time.UnixNano(), 10)
+			b = append(b, `",`...)
+			b = appendJSONString(b, string(batch[i].Line))
+			b = append(b, ']')
+		}
+		b = append(b, "]}"...)
+	}
+	return append(b, "]}"...), nil
+}
+
+// ElasticPayload builds an Elasticsearch _bulk request indexing each record
+// into Index with an "@timestamp".
+type ElasticPayload struct {
+	Index string
+}
+
+func (ElasticPayload) ContentType() string {
+	return "application/x-ndjson"
+}
+
+func (p ElasticPayload) Build(b []byte, batch []HTTPEntry, labels map[string]string) ([]byte, error) {
+	if p.Index == "" {
+		return nil, errors.New("elasticsearch index missing")
+	}
+	for i := range batch {
+		b = append(b, `{"index":{"_index":`...)
+		b = appendJSONString(b, p.Index)
+		b = append(b, "}}\n"...)
+		start := len(b)
+		b = batch[i].Doc(b, labels)
+		ts := batch[i].Record.time.UTC().AppendFormat(nil, time.RFC3339Nano)
+		tail := append([]byte(nil), b[start+1:]...)
+		b = append(b[:start], `{"@timestamp":"`...)
+		b = append(b, ts...)
+		b = append(b, `",`...)
+		b = append(b, tail...)
+		b = append(b, '\n')
+	}
+	return b, nil
+}
+
+// rejected counts the items of a _bulk response which failed, reason is
+// the error of the first.
+func (ElasticPayload) rejected(resp io.Reader) (n int, reason string) {
+	var bulk struct {
+		Errors bool `json:"errors"`
+		Items  []map[string]struct {
+			Status int             `json:"status"`
+			Error  json.RawMessage `json:"error"`
+		} `json:"items"`
+	}
+	if err := json.NewDecoder(resp).Decode(&bulk); err != nil || !bulk.Errors {
+		return 0, ""
+	}
+	for _, item := range bulk.Items {
+		for _, result := range item {
+			if result.Status/100 == 2 {
+				continue
+			}
+			if n == 0 {
+				reason = string(result.Error)
+			}
+			n++
+		}
+	}
+	return n, reason
+}
+
+// rejecter is implemented by the builders whose backend answers for each
+// record of a batch, rejected reads a successful response and counts the
+// records it refused.
+type rejecter interface {
+	rejected(resp io.Reader) (n int, reason string)
+}
+
+// JSONArrayPayload builds a JSON array of records for generic webhooks.
+type JSONArrayPayload struct{}
+
+func (JSONArrayPayload) ContentType() string {
+	return "application/json"
+}
+
+func (JSONArrayPayload) Build(b []byte, batch []HTTPEntry, labels map[string]string) ([]byte, error) {
+	b = append(b, '[')
+	for i := range batch {
+		if i > 0 {
+			b = append(b, ',')
+		}
+		b = batch[i].Doc(b, labels)
+	}
+	return append(b, ']'), nil
+}
+
+type httpBody struct {
+	b []byte
+	n int // records in b
+}
+
+// HTTPWriter pushes records in batches to a log backend. A batch is sent
+// once it holds the batch size, when the interval elapsed at the next
+// flush, or at once for PANIC and FATAL records. Requests are made by a
+// goroutine of the writer; batches finding its queue full are dropped.
+// Failed requests are retried with backoff, a batch is dropped after the
+// last retry or on a client error other than 429. The records an
+// Elasticsearch bulk request refused are dropped too.
+type HTTPWriter struct {
+	writeCount
+
+	logLevelFloor int
+	logLevelCeil  int
+
+	url      string
+	builder  PayloadBuilder
+	client   *http.Client
+	header   http.Header
+	labels   map[string]string
+	gzip     bool
+	size     int
+	interval time.Duration
+	retries  int
+
+	batch  []HTTPEntry
+	first  time.Time // arrival of the oldest record of batch
+	sender *sender
+
+	// owned by the sender goroutine
+	bodies   []httpBody // built batches waiting to be sent
+	attempts int        // failed sends of bodies[0]
+	retry    backoff
+
+	dropped uint64
+}
+
+func NewHTTPWriter(url string, builder PayloadBuilder) *HTTPWriter {
+	return &HTTPWriter{
+		logLevelCeil: PUBLIC,
+		url:          url,
+		builder:      builder,
+		client:       &http.Client{Timeout: 10 * time.Second},
+		header:       make(http.Header),
+		size:         http_batch_cnt_default,
+		interval:     time.Second,
+		retries:      3,
+		retry:        newBackoff(500*time.Millisecond, 30*time.Second),
+	}
+}
+
+func (w *HTTPWriter) SetLogLevelFloor(floor int) {
+	w.logLevelFloor = floor
+}
+
+func (w *HTTPWriter) SetLogLevelCeil(ceil int) {
+	w.logLevelCeil = ceil
+}
+
+func (w *HTTPWriter) SetClient(client *http.Client) {
+	w.client = client
+}
+
+// SetHeader adds a header to every request, e.g. Authorization.
+func (w *HTTPWriter) SetHeader(key, value string) {
+	w.header.Set(key, value)
+}
+
+// SetLabels sets static labels, stream labels for Loki and extra keys of
+// each document otherwise.
+func (w *HTTPWriter) SetLabels(labels map[string]string) {
+	w.labels = labels
+}
+
+func (w *HTTPWriter) SetGzip(on bool) {
+	w.gzip = on
+}
+
+func (w *HTTPWriter) SetBatch(size int, interval time.Duration) {
+	if size > 0 {
+		w.size = size
+	}
+	if interval > 0 {
+		w.interval = interval
+	}
+}
+
+// SetRetries sets how many times a failed batch is sent again.
+func (w *HTTPWriter) SetRetries(n int) {
+	w.retries = n
+}
+
+// Dropped returns how many records were given up.
+func (w *HTTPWriter) Dropped() uint64 {
+	return atomic.LoadUint64(&w.dropped)
+}
+
+func (w *HTTPWriter) Init() error {
+	if w.url == "" {
+		return errors.New("http writer url missing")
+	}
+	if w.builder == nil {
+		return errors.New("http writer payload builder missing")
+	}
+	w.sender = startSender(w, http_pending_batches_max, &w.dropped)
+	return nil
+}
+
+func (w *HTTPWriter) accepts(level int) bool {
+	return levelBetween(level, w.logLevelFloor, w.logLevelCeil)
+}
+
+func (w *HTTPWriter) readsFields() {}
+
+func (w *HTTPWriter) Write(enc *textEncoder) error {
+	if enc.rec == nil || !w.accepts(enc.level) {
+		return nil
+	}
+	line := enc.bytes
+	if n := len(line); n > 0 && line[n-1] == '\n' {
+		line = line[:n-1]
+	}
+	if len(w.batch) == 0 {
+		w.first = time.Now()
+	}
+	w.batch = append(w.batch, HTTPEntry{
+		Record: *enc.rec,
+		Line:   append([]byte(nil), line...),
+		limits: enc.limits,
+	})
+	if enc.level == PANIC || enc.level == FATAL {
+		err := w.send()
+		w.sender.sync()
+		return err
+	}
+	if len(w.batch) >= w.size {
+		return w.send()
+	}
+	return nil
+}
+
+// Flush sends the pending batch once the interval elapsed and returns an
+// error the last requests met.
+func (w *HTTPWriter) Flush() error {
+	if w.sender == nil {
+		return nil
+	}
+	if len(w.batch) > 0 && time.Since(w.first) >= w.interval {
+		if err := w.send(); err != nil {
+			return err
+		}
+	}
+	return w.sender.err()
+}
+
+// Close sends what is pending, without waiting for failed batches, and
+// closes the idle connections.
+func (w *HTTPWriter) Close() error {
+	if w.sender == nil {
+		return nil
+	}
+	err := w.send()
+	if cerr := w.sender.close(); err == nil {
+		err = cerr
+	}
+	w.sender = nil
+	w.client.CloseIdleConnections()
+	return err
+}
+
+// send builds the pending batch and queues it for the sender.
+func (w *HTTPWriter) send() error {
+	if len(w.batch) == 0 {
+		return nil
+	}
+	body, err := w.builder.Build(nil, w.batch, w.labels)
+	n := len(w.batch)
+	for i := range w.batch {
+		w.batch[i] = HTTPEntry{}
+	}
+	w.batch = w.batch[:0]
+	if err != nil {
+		atomic.AddUint64(&w.dropped, uint64(n))
+		return err
+	}
+	w.sender.put(body, n)
+	return nil
+}
+
+func (w *HTTPWriter) deliver(body []byte, n int) error {
+	if len(w.bodies) == http_pending_batches_max {
+		atomic.AddUint64(&w.dropped, uint64(w.bodies[0].n))
+		w.bodies[0] = httpBody{}
+		w.bodies = w.bodies[1:]
+		w.attempts = 0
+	}
+	w.bodies = append(w.bodies, httpBody{b: body, n: n})
+	return w.drain()
+}
+
+func (w *HTTPWriter) resend() error {
+	return w.drain()
+}
+
+// shutdown tries the waiting batches once more, ignoring the backoff, and
+// counts those still failing as dropped.
+func (w *HTTPWriter) shutdown() error {
+	w.retry.reset()
+	err := w.drain()
+	for _, body := range w.bodies {
+		atomic.AddUint64(&w.dropped, uint64(body.n))
+	}
+	w.bodies = nil
+	return err
+}
+
+func (w *HTTPWriter) drain() error {
+	for len(w.bodies) > 0 {
+		now := time.Now()
+		if !w.retry.ready(now) {
+			return nil
+		}
+		rejected, retry, err := w.post(w.bodies[0].b)
+		if err != nil && retry && w.attempts < w.retries {
+			w.attempts++
+			w.retry.fail(now)
+			return err
+		}
+		if err != nil && rejected == 0 {
+			rejected = w.bodies[0].n
+		}
+		atomic.AddUint64(&w.dropped, uint64(rejected))
+		if rejected < w.bodies[0].n {
+			w.count(w.bodies[0].n-rejected, len(w.bodies[0].b))
+		}
+		w.retry.reset()
+		w.attempts = 0
+		w.bodies[0] = httpBody{}
+		w.bodies = w.bodies[1:]
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// post sends body, retry tells if a failure may succeed later. rejected
+// counts the records the backend refused from a body it accepted.
+func (w *HTTPWriter) post(body []byte) (rejected int, retry bool, err error) {
+	if w.gzip {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		zw.Write(body)
+		zw.Close()
+		body = buf.Bytes()
+	}
+	req, err := http.NewRequest("POST", w.url, bytes.NewReader(body))
+	if err != nil {
+		return 0, false, err
+	}
+	for k, v := range w.header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", w.builder.ContentType())
+	if w.gzip {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return 0, true, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 == 2 {
+		if r, ok := w.builder.(rejecter); ok {
+			var reason string
+			if rejected, reason = r.rejected(resp.Body); rejected > 0 {
+				err = fmt.Errorf("push to %s: %d records rejected: %s", w.url, rejected, reason)
+			}
+		}
+		io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 64<<10))
+		return rejected, false, err
+	}
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 64<<10))
+	err = fmt.Errorf("push to %s: %s", w.url, resp.Status)
+	return 0, resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500, err
+}
@@ -0,0 +1,170 @@
+package clog
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestHTTPWriterLoki(t *testing.T) {
+	var mu sync.Mutex
+	var pushes []map[string]interface{}
+	fail := true
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if fail {
+			fail = false
+			http.Error(rw, "busy", http.StatusServiceUnavailable)
+			return
+		}
+		if req.Header.Get("Content-Encoding") != "gzip" {
+			t.Errorf("body not compressed")
+		}
+		zr, err := gzip.NewReader(req.Body)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		var push map[string]interface{}
+		if err := json.NewDecoder(zr).Decode(&push); err != nil {
+			t.Error(err)
+			return
+		}
+		pushes = append(pushes, push)
+		rw.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	w := NewHTTPWriter(srv.URL, LokiPayload{})
+	w.SetGzip(true)
+	w.SetBatch(2, time.Hour)
+	w.SetLabels(map[string]string{"app": "api"})
+	w.retry = newBackoff(time.Millisecond, time.Millisecond)
+	if err := w.Init(); err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range []*Record{
+		{time: time.Now(), info: "started", level: INFO},
+		{time: time.Now(), info: "slow", level: WARNING},
+	} {
+		w.Write(&textEncoder{bytes: []byte(r.info + "\n"), level: r.level, rec: r})
+	}
+	// the first request fails, the batch is sent again
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		mu.Lock()
+		n := len(pushes)
+		mu.Unlock()
+		if n > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("failed batch not retried")
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(pushes) != 1 || w.Dropped() != 0 {
+		t.Fatalf("got %d pushes, %d dropped", len(pushes), w.Dropped())
+	}
+	streams := pushes[0]["streams"].([]interface{})
+	if len(streams) != 2 {
+		t.Fatalf("got %d streams", len(streams))
+	}
+	first := streams[0].(map[string]interface{})
+	labels := first["stream"].(map[string]interface{})
+	if labels["level"] != "info" || labels["app"] != "api" {
+		t.Fatalf("unexpected labels %v", labels)
+	}
+	value := first["values"].([]interface{})[0].([]interface{})
+	if value[1] != "started" {
+		t.Fatalf("unexpected line %v", value)
+	}
+}
+
+func TestHTTPWriterQueueFull(t *testing.T) {
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	var mu sync.Mutex
+	pushes := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		select {
+		case started <- struct{}{}:
+		default:
+		}
+		<-release
+		mu.Lock()
+		pushes++
+		mu.Unlock()
+	}))
+	defer srv.Close()
+
+	w := NewHTTPWriter(srv.URL, JSONArrayPayload{})
+	w.SetBatch(1, time.Hour)
+	if err := w.Init(); err != nil {
+		t.Fatal(err)
+	}
+	write := func() {
+		r := &Record{time: time.Now(), info: "m", level: INFO}
+		if err := w.Write(&textEncoder{bytes: []byte("m\n"), level: INFO, rec: r}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write()
+	<-started
+	// the writer goes on while the request hangs, past the queue it drops
+	for i := 0; i < http_pending_batches_max+5; i++ {
+		write()
+	}
+	if w.Dropped() != 5 {
+		t.Fatalf("dropped %d, want 5", w.Dropped())
+	}
+	close(release)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if pushes != http_pending_batches_max+1 {
+		t.Fatalf("got %d pushes", pushes)
+	}
+}
+
+func TestHTTPWriterElasticRejected(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Write([]byte(`{"took":3,"errors":true,"items":[` +
+			`{"index":{"status":201}},` +
+			`{"index":{"status":400,"error":{"type":"mapper_parsing_exception"}}},` +
+			`{"index":{"status":201}}]}`))
+	}))
+	defer srv.Close()
+
+	w := NewHTTPWriter(srv.URL, ElasticPayload{Index: "logs"})
+	w.SetBatch(3, time.Hour)
+	if err := w.Init(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		r := &Record{time: time.Now(), info: "m", level: INFO}
+		w.Write(&textEncoder{bytes: []byte("m\n"), level: INFO, rec: r})
+	}
+	w.sender.sync()
+	if err := w.Flush(); err == nil || !strings.Contains(err.Error(), "mapper_parsing_exception") {
+		t.Fatalf("rejection not reported: %v", err)
+	}
+	if records, _ := w.Written(); records != 2 || w.Dropped() != 1 {
+		t.Fatalf("%d written, %d dropped", records, w.Dropped())
+	}
+	w.Close()
+	if err := w.Flush(); err != nil {
+		t.Fatalf("Flush after Close: %v", err)
+	}
+}
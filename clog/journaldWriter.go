@@ -0,0 +1,196 @@
+package clog
+
+import (
+	"encoding/binary"
+	"net"
+	"os"
+	"path"
+	"strconv"
+)
+
+const journald_socket_default = "/run/systemd/journal/socket"
+
+// JournaldWriter sends records to systemd-journald over its native protocol,
+// each field of a record becoming an upper-cased journal field next to
+// MESSAGE, PRIORITY, CODE_FILE and CODE_LINE. Entries too large for a
+// datagram are passed in a sealed memfd.
+type JournaldWriter struct {
+	writeCount
+
+	logLevelFloor int
+	logLevelCeil  int
+
+	socket     string
+	identifier string
+	public     int
+	conn       *net.UnixConn
+
+	buf []byte
+}
+
+// NewJournaldWriter returns a writer tagging entries with identifier,
+// the program name by default.
+func NewJournaldWriter(identifier string) *JournaldWriter {
+	if identifier == "" {
+		identifier = path.Base(os.Args[0])
+	}
+	return &JournaldWriter{
+		logLevelCeil: PUBLIC,
+		socket:       journald_socket_default,
+		identifier:   identifier,
+		public:       LOG_NOTICE,
+	}
+}
+
+func (w *JournaldWriter) SetLogLevelFloor(floor int) {
+	w.logLevelFloor = floor
+}
+
+func (w *JournaldWriter) SetLogLevelCeil(ceil int) {
+	w.logLevelCeil = ceil
+}
+
+// SetSocket changes the journal socket, for tests.
+func (w *JournaldWriter) SetSocket(socket string) {
+	w.socket = socket
+}
+
+// SetPublicSeverity sets the PRIORITY of PUBLIC records, LOG_NOTICE by
+// default.
+func (w *JournaldWriter) SetPublicSeverity(severity int) {
+	w.public = severity
+}
+
+func (w *JournaldWriter) Init() error {
+	return w.connect()
+}
+
+func (w *JournaldWriter) connect() error {
+	if w.conn != nil {
+		return nil
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: w.socket, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	w.conn = conn
+	return nil
+}
+
+func (w *JournaldWriter) accepts(level int) bool {
+	return levelBetween(level, w.logLevelFloor, w.logLevelCeil)
+}
+
+func (w *JournaldWriter) readsFields() {}
+
+func (w *JournaldWriter) Write(enc *textEncoder) error {
+	r := enc.rec
+	if r == nil || !w.accepts(r.level) {
+		return nil
+	}
+	if err := w.connect(); err != nil {
+		return err
+	}
+	w.buf = w.entry(w.buf[:0], r)
+	_, err := w.conn.Write(w.buf)
+	if err != nil && isMsgTooLarge(err) {
+		err = sendJournalFD(w.conn, w.buf)
+	}
+	if err != nil {
+		// the journal may have restarted, dial again next time
+		w.conn.Close()
+		w.conn = nil
+		return err
+	}
+	w.count(1, len(w.buf))
+	return nil
+}
+
+func (w *JournaldWriter) Close() error {
+	if w.conn == nil {
+		return nil
+	}
+	err := w.conn.Close()
+	w.conn = nil
+	return err
+}
+
+func (w *JournaldWriter) priority(level int) int {
+	switch level {
+	case TRACE, DEBUG:
+		return LOG_DEBUG
+	case INFO:
+		return LOG_INFO
+	case WARNING:
+		return LOG_WARNING
+	case ERROR:
+		return LOG_ERR
+	case PANIC, FATAL:
+		return LOG_CRIT
+	}
+	return w.public
+}
+
+func (w *JournaldWriter) entry(b []byte, r *Record) []byte {
+	b = appendJournalField(b, "MESSAGE", r.message())
+	b = appendJournalField(b, "PRIORITY", strconv.Itoa(w.priority(r.level)))
+	b = appendJournalField(b, "SYSLOG_IDENTIFIER", w.identifier)
+	b = appendJournalField(b, "CODE_FILE", r.code)
+	b = appendJournalField(b, "CODE_LINE", strconv.Itoa(r.line))
+	if r.name != "" {
+		b = appendJournalField(b, "LOGGER", r.name)
+	}
+	prefix := ""
+	for i := range r.fields {
+		f := &r.fields[i]
+		if f.fieldType == namespaceType {
+			prefix += f.key + "_"
+			continue
+		}
+		b = appendJournalField(b, journalKey(prefix+f.key), string(f.WriteValue(nil)))
+	}
+	return b
+}
+
+// journalKey upper-cases key and replaces what journald refuses: anything
+// but A-Z, 0-9 and '_', a leading '_' or digit, more than 64 bytes.
+func journalKey(key string) string {
+	b := make([]byte, 0, len(key)+1)
+	for i := 0; i < len(key) && len(b) < 64; i++ {
+		c := key[i]
+		switch {
+		case c >= 'a' && c <= 'z':
+			c -= 'a' - 'A'
+		case c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '_':
+		default:
+			c = '_'
+		}
+		if len(b) == 0 && (c == '_' || c >= '0' && c <= '9') {
+			b = append(b, 'F')
+		}
+		b = append(b, c)
+	}
+	if len(b) == 0 {
+		return "FIELD"
+	}
+	return string(b)
+}
+
+// appendJournalField writes KEY=value, or with a binary length when value
+// holds a newline.
+func appendJournalField(b []byte, key, value string) []byte {
+	b = append(b, key...)
+	for i := 0; i < len(value); i++ {
+		if value[i] == '\n' {
+			b = append(b, '\n')
+			var n [8]byte
+			binary.LittleEndian.PutUint64(n[:], uint64(len(value)))
+			b = append(b, n[:]...)
+			b = append(b, value...)
+			return append(b, '\n')
+		}
+	}
+	b = append(b, '=')
+	b = append(b, value...)
+	return append(b, '\n')
+}
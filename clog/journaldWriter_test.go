@@ -0,0 +1,103 @@
+package clog
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// readJournal receives one entry from a journald stand-in, following a
+// passed file descriptor.
+func readJournal(t *testing.T, conn *net.UnixConn) []byte {
+	buf := make([]byte, 1<<16)
+	oob := make([]byte, 64)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, oobn, _, _, err := conn.ReadMsgUnix(buf, oob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if oobn == 0 {
+		return buf[:n]
+	}
+	msgs, err := syscall.ParseSocketControlMessage(oob[:oobn])
+	if err != nil {
+		t.Fatal(err)
+	}
+	fds, err := syscall.ParseUnixRights(&msgs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := os.NewFile(uintptr(fds[0]), "entry")
+	defer f.Close()
+	f.Seek(0, 0)
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestJournaldWriter(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("journald runs on linux")
+	}
+	dir, err := ioutil.TempDir("", "clog-journal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "socket")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	w := NewJournaldWriter("app")
+	w.SetSocket(socket)
+	if err := w.Init(); err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	r := &Record{
+		time:   time.Now(),
+		code:   "main.go",
+		line:   12,
+		info:   "request done",
+		level:  ERROR,
+		fields: []Field{String("user-id", "u1"), String("body", "a\nb")},
+	}
+	if err := w.Write(&textEncoder{rec: r}); err != nil {
+		t.Fatal(err)
+	}
+	entry := readJournal(t, conn)
+	for _, want := range []string{"MESSAGE=request done\n", "PRIORITY=3\n",
+		"CODE_FILE=main.go\n", "CODE_LINE=12\n", "USER_ID=u1\n"} {
+		if !bytes.Contains(entry, []byte(want)) {
+			t.Fatalf("%q missing in %q", want, entry)
+		}
+	}
+	var size [8]byte
+	binary.LittleEndian.PutUint64(size[:], 3)
+	if !bytes.Contains(entry, []byte("BODY\n"+string(size[:])+"a\nb\n")) {
+		t.Fatalf("multiline field not binary encoded in %q", entry)
+	}
+
+	// too large for a datagram, goes through a file descriptor
+	r.fields = []Field{String("blob", strings.Repeat("x", 4<<20))}
+	if err := w.Write(&textEncoder{rec: r}); err != nil {
+		t.Fatal(err)
+	}
+	if entry = readJournal(t, conn); !bytes.Contains(entry, []byte("BLOB=xxx")) {
+		t.Fatalf("large entry not received, %d bytes", len(entry))
+	}
+}
@@ -0,0 +1,87 @@
+package clog
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"runtime"
+	"syscall"
+	"unsafe"
+)
+
+// memfd_create numbers, the syscall package lacks them.
+var sysMemfdCreate = map[string]uintptr{
+	"386":     356,
+	"amd64":   319,
+	"arm":     385,
+	"arm64":   279,
+	"ppc64le": 360,
+	"riscv64": 279,
+	"s390x":   350,
+}
+
+const (
+	mfdAllowSealing = 0x2
+	fAddSeals       = 1033
+	sealAll         = 0x1 | 0x2 | 0x4 | 0x8 // seal, shrink, grow, write
+)
+
+func isMsgTooLarge(err error) bool {
+	if op, ok := err.(*net.OpError); ok {
+		if sys, ok := op.Err.(*os.SyscallError); ok {
+			return sys.Err == syscall.EMSGSIZE || sys.Err == syscall.ENOBUFS
+		}
+	}
+	return false
+}
+
+// journalFile returns a sealed memfd holding data, or an unlinked file
+// in /dev/shm where memfd is missing.
+func journalFile(data []byte) (*os.File, error) {
+	if nr, ok := sysMemfdCreate[runtime.GOARCH]; ok {
+		name := []byte("clog-journal\x00")
+		fd, _, errno := syscall.Syscall(nr, uintptr(unsafe.Pointer(&name[0])), mfdAllowSealing, 0)
+		if errno == 0 {
+			f := os.NewFile(fd, "memfd:clog-journal")
+			if _, err := f.Write(data); err != nil {
+				f.Close()
+				return nil, err
+			}
+			syscall.Syscall(syscall.SYS_FCNTL, fd, fAddSeals, sealAll)
+			return f, nil
+		}
+	}
+	f, err := ioutil.TempFile("/dev/shm", "clog-journal")
+	if err != nil {
+		return nil, err
+	}
+	os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// sendJournalFD passes data to journald as a file descriptor.
+func sendJournalFD(conn *net.UnixConn, data []byte) error {
+	f, err := journalFile(data)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	// WriteMsgUnix refuses connected datagram sockets
+	rights := syscall.UnixRights(int(f.Fd()))
+	werr := raw.Write(func(fd uintptr) bool {
+		err = syscall.Sendmsg(int(fd), nil, rights, nil, 0)
+		return err != syscall.EAGAIN
+	})
+	if werr != nil {
+		return werr
+	}
+	return err
+}
@@ -0,0 +1,17 @@
+//go:build !linux
+// +build !linux
+
+package clog
+
+import (
+	"errors"
+	"net"
+)
+
+func isMsgTooLarge(err error) bool {
+	return false
+}
+
+func sendJournalFD(conn *net.UnixConn, data []byte) error {
+	return errors.New("journal entry too large")
+}
@@ -0,0 +1,113 @@
+package clog
+
+import (
+	"math"
+	"strconv"
+	"unicode/utf8"
+)
+
+const hexDigits = "0123456789abcdef"
+
+// JSON writes r into enc as a single line JSON object.
+func (r *Record) JSON(enc *textEncoder) {
+	start := len(enc.bytes)
+	enc.bytes = append(enc.bytes, `{"time":`...)
+	enc.bytes = append(enc.bytes, '"')
+	enc.bytes = r.time.AppendFormat(enc.bytes, logger_default.layout)
+	enc.bytes = append(enc.bytes, `","level":"`...)
+	enc.bytes = append(enc.bytes, LEVEL_FLAGS[r.level]...)
+	enc.bytes = append(enc.bytes, `","caller":"`...)
+	enc.bytes = appendJSONEscaped(enc.bytes, r.code)
+	enc.bytes = append(enc.bytes, ':')
+	enc.bytes = strconv.AppendInt(enc.bytes, int64(r.line), 10)
+	enc.bytes = append(enc.bytes, '"')
+	if r.name != "" {
+		enc.bytes = append(enc.bytes, `,"logger":`...)
+		enc.bytes = appendJSONString(enc.bytes, r.name)
+	}
+	enc.bytes = append(enc.bytes, `,"msg":`...)
+	enc.bytes = appendJSONString(enc.bytes, truncateString(r.message(), enc.limits.MaxMessage))
+	if len(r.fields) > 0 {
+		je := getJSONEncoder(enc.bytes)
+		je.maxString = enc.limits.MaxField
+		for i := range r.fields {
+			r.fields[i].AddTo(je)
+		}
+		je.closeNamespaces()
+		enc.bytes = putJSONEncoder(je)
+	}
+	enc.bytes = append(enc.bytes, "}\n"...)
+
+	// an oversized record is written again without its fields
+	if max := enc.limits.MaxRecord; max > 0 && len(enc.bytes)-start > max {
+		size := len(enc.bytes) - start
+		short := *r
+		short.hight, short.args = true, nil
+		short.info = truncateString(r.message(), max/2)
+		short.fields = []Field{Int("truncated", size)}
+		limits := enc.limits
+		enc.limits = Limits{}
+		enc.bytes = enc.bytes[:start]
+		short.JSON(enc)
+		enc.limits = limits
+	}
+}
+
+func appendJSONFloat(b []byte, v float64) []byte {
+	switch {
+	case math.IsNaN(v):
+		return append(b, `"NaN"`...)
+	case math.IsInf(v, 1):
+		return append(b, `"+Inf"`...)
+	case math.IsInf(v, -1):
+		return append(b, `"-Inf"`...)
+	}
+	return strconv.AppendFloat(b, v, 'f', -1, 64)
+}
+
+func appendJSONString(b []byte, s string) []byte {
+	b = append(b, '"')
+	b = appendJSONEscaped(b, s)
+	return append(b, '"')
+}
+
+// appendJSONEscaped escapes s for use inside a JSON string, invalid UTF-8
+// is replaced by U+FFFD.
+func appendJSONEscaped(b []byte, s string) []byte {
+	start := 0
+	for i := 0; i < len(s); {
+		c := s[i]
+		if c < utf8.RuneSelf {
+			if c >= 0x20 && c != '"' && c != '\\' {
+				i++
+				continue
+			}
+			b = append(b, s[start:i]...)
+			switch c {
+			case '"', '\\':
+				b = append(b, '\\', c)
+			case '\n':
+				b = append(b, '\\', 'n')
+			case '\r':
+				b = append(b, '\\', 'r')
+			case '\t':
+				b = append(b, '\\', 't')
+			default:
+				b = append(b, '\\', 'u', '0', '0', hexDigits[c>>4], hexDigits[c&0xf])
+			}
+			i++
+			start = i
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if r == utf8.RuneError && size == 1 {
+			b = append(b, s[start:i]...)
+			b = append(b, `�`...)
+			i++
+			start = i
+			continue
+		}
+		i += size
+	}
+	return append(b, s[start:]...)
+}
@@ -0,0 +1,49 @@
+package clog
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// LoadKey reads a key from "file:/path/to/key" or "env:NAME". Hex or
+// base64 text decoding to 16, 24 or 32 bytes is decoded, anything else is
+// used as is.
+func LoadKey(spec string) ([]byte, error) {
+	var raw []byte
+	switch {
+	case strings.HasPrefix(spec, "file:"):
+		b, err := ioutil.ReadFile(spec[len("file:"):])
+		if err != nil {
+			return nil, err
+		}
+		raw = bytes.TrimSpace(b)
+	case strings.HasPrefix(spec, "env:"):
+		name := spec[len("env:"):]
+		v, ok := os.LookupEnv(name)
+		if !ok {
+			return nil, errors.New("key variable " + name + " is not set")
+		}
+		raw = []byte(strings.TrimSpace(v))
+	default:
+		return nil, errors.New("Invalid key spec (" + spec + "), want file:PATH or env:NAME")
+	}
+	if len(raw) == 0 {
+		return nil, errors.New("empty key (" + spec + ")")
+	}
+	if k, err := hex.DecodeString(string(raw)); err == nil && aesKeySize(len(k)) {
+		return k, nil
+	}
+	if k, err := base64.StdEncoding.DecodeString(string(raw)); err == nil && aesKeySize(len(k)) {
+		return k, nil
+	}
+	return raw, nil
+}
+
+func aesKeySize(n int) bool {
+	return n == 16 || n == 24 || n == 32
+}
@@ -0,0 +1,125 @@
+package clog
+
+import (
+	"strconv"
+	"unicode/utf8"
+)
+
+// pool_bytes_cnt_max is the largest buffer given back to textPool, bigger
+// ones are left to the garbage collector.
+const pool_bytes_cnt_max = 64 << 10
+
+// Limits bounds the size of records, a zero limit is no limit. Text cut by
+// a limit ends with a "...[truncated N bytes]" marker.
+type Limits struct {
+	MaxMessage int `json:"MaxMessage"` // bytes of the message
+	MaxField   int `json:"MaxField"`   // bytes of each field value
+	MaxRecord  int `json:"MaxRecord"`  // bytes of a whole record
+}
+
+// truncatedMarkerRoom is what the marker takes besides its number.
+const truncatedMarkerRoom = len("...[truncated  bytes]")
+
+// truncatedMarkerLen is the length of the marker for at most n bytes.
+func truncatedMarkerLen(n int) int {
+	return truncatedMarkerRoom + len(strconv.Itoa(n))
+}
+
+func appendTruncatedMarker(b []byte, n int) []byte {
+	b = append(b, "...[truncated "...)
+	b = strconv.AppendInt(b, int64(n), 10)
+	return append(b, " bytes]"...)
+}
+
+// runeStart moves cut back to the start of the UTF-8 sequence it falls in.
+func runeStart(b []byte, start, cut int) int {
+	for cut > start && !utf8.RuneStart(b[cut]) {
+		cut--
+	}
+	return cut
+}
+
+// escapeStart moves cut back to the start of the escape sequence written
+// by appendEscaped it falls in, so no escape is left half.
+func escapeStart(b []byte, start, cut int) int {
+	for i := start; i < cut; {
+		if b[i] != '\\' || i+1 >= len(b) {
+			i++
+			continue
+		}
+		n := 2
+		switch b[i+1] {
+		case 'x':
+			n = 4
+		case 'u':
+			n = 6
+		}
+		if i+n > cut {
+			return i
+		}
+		i += n
+	}
+	return cut
+}
+
+// truncateCut is where b is cut to keep at most keep bytes after start,
+// on a rune start and, for escaped text, outside an escape.
+func truncateCut(b []byte, start, keep int, escaped bool) int {
+	cut := runeStart(b, start, start+keep)
+	if escaped {
+		cut = escapeStart(b, start, cut)
+	}
+	return cut
+}
+
+// truncateTail cuts what was appended to b after start to max bytes,
+// escaped tells whether it went through escapeTail.
+func truncateTail(b []byte, start, max int, escaped bool) []byte {
+	if max <= 0 || len(b)-start <= max {
+		return b
+	}
+	cut := truncateCut(b, start, max, escaped)
+	dropped := len(b) - cut
+	return appendTruncatedMarker(b[:cut], dropped)
+}
+
+func truncateString(s string, max int) string {
+	if max <= 0 || len(s) <= max {
+		return s
+	}
+	return string(truncateTail([]byte(s), 0, max, false))
+}
+
+// truncateRecord cuts a text record longer than max, marker and newline
+// included. A max too small for the marker cuts without one.
+func truncateRecord(b []byte, max int, escaped bool) []byte {
+	if max <= 0 || len(b) <= max {
+		return b
+	}
+	keep := max - 1 - truncatedMarkerLen(len(b))
+	if keep < 0 {
+		cut := truncateCut(b, 0, max-1, escaped)
+		return append(b[:cut], '\n')
+	}
+	cut := truncateCut(b, 0, keep, escaped)
+	b = appendTruncatedMarker(b[:cut], len(b)-cut)
+	return append(b, '\n')
+}
+
+// putTextEncoder returns enc to textPool unless its buffer grew too big.
+func putTextEncoder(enc *textEncoder) {
+	enc.rec = nil
+	if cap(enc.bytes) > pool_bytes_cnt_max {
+		return
+	}
+	textPool.Put(enc)
+}
+
+// SetLimits bounds the size of the records of l, see Limits.
+func (l *Logger) SetLimits(limits Limits) {
+	l.limits = limits
+}
+
+func SetLimits(limits Limits) {
+	logger_default.SetLimits(limits)
+}
@@ -0,0 +1,72 @@
+package clog
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTruncateRecordFitsMax(t *testing.T) {
+	record := []byte(strings.Repeat("abcdefghij", 10) + "\n")
+	for max := 1; max < len(record); max++ {
+		b := truncateRecord(append([]byte(nil), record...), max, true)
+		if len(b) > max {
+			t.Errorf("max %d: %d bytes %q", max, len(b), b)
+		}
+		if b[len(b)-1] != '\n' {
+			t.Errorf("max %d: no newline in %q", max, b)
+		}
+	}
+	b := truncateRecord(append([]byte(nil), record...), 40, true)
+	if want := "abcdefghijabcde...[truncated 86 bytes]\n"; string(b) != want {
+		t.Errorf("got %q, want %q", b, want)
+	}
+	if b := truncateRecord(append([]byte(nil), record...), 10, true); string(b) != "abcdefghi\n" {
+		t.Errorf("max 10 without marker: %q", b)
+	}
+}
+
+func TestTruncateTailEscapeBoundary(t *testing.T) {
+	for _, c := range []struct {
+		in   string
+		max  int
+		want string
+	}{
+		{`ab\ncd`, 3, `ab`},
+		{`ab\ncd`, 4, `ab\n`},
+		{`ab\x07cd`, 5, `ab`},
+		{`ab\x07cd`, 6, `ab\x07`},
+		{`ab\u2028cd`, 7, `ab`},
+		{`ab\\ncd`, 3, `ab`},
+		{`ab\\ncd`, 4, `ab\\`},
+		{`ab\\\ncd`, 5, `ab\\`},
+	} {
+		b := truncateTail([]byte("k="+c.in), 2, c.max, true)
+		want := "k=" + c.want + "...[truncated " + strconv.Itoa(len(c.in)-len(c.want)) + " bytes]"
+		if string(b) != want {
+			t.Errorf("truncateTail(%q, %d) = %q, want %q", c.in, c.max, b, want)
+		}
+	}
+	// text which was not escaped is cut where max falls
+	if b := truncateTail([]byte(`ab\ncd`), 0, 3, false); string(b) != `ab\...[truncated 3 bytes]` {
+		t.Errorf("raw text cut at %q", b)
+	}
+}
+
+func TestRecordFieldTruncatedOnEscape(t *testing.T) {
+	r := &Record{
+		time:   time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC),
+		code:   "x.go",
+		line:   7,
+		info:   "m",
+		level:  INFO,
+		hight:  true,
+		fields: []Field{String("k", "a\nb")},
+	}
+	enc := &textEncoder{escape: true, limits: Limits{MaxField: 2}}
+	r.Bytes(enc)
+	if want := " k=a...[truncated 3 bytes]\n"; !strings.HasSuffix(string(enc.bytes), want) {
+		t.Errorf("text %q, want suffix %q", enc.bytes, want)
+	}
+}
@@ -1,20 +1,24 @@
 package clog
 
 import (
+	"errors"
 	"fmt"
-	"log"
+	"io"
 	"math/rand"
+	"net/http"
+	"os"
 	"path"
 	"runtime"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 var (
 	LEVEL_FLAGS = [...]string{"TRACE", "DEBUG", "INFO", "WARN", "ERROR", "FATAL",
-		"PUBLIC"}
+		"PUBLIC", "PANIC"}
 )
 
 const (
@@ -25,7 +29,53 @@ const (
 	ERROR
 	FATAL
 	PUBLIC
+	// PANIC comes last to keep the values of the older levels, it ranks
+	// between ERROR and FATAL, see levelRank.
+	PANIC
 )
+
+// levelRank orders the levels by severity, levels are compared through it
+// rather than by value.
+func levelRank(level int) int {
+	if level == PANIC {
+		return 2*ERROR + 1
+	}
+	return 2 * level
+}
+
+// atLeast reports whether level is at least as severe as min.
+func atLeast(level, min int) bool {
+	return levelRank(level) >= levelRank(min)
+}
+
+// levelBetween reports whether level lies within floor and ceil.
+func levelBetween(level, floor, ceil int) bool {
+	return atLeast(level, floor) && atLeast(ceil, level)
+}
+
+// ParseLevel converts a level name such as "debug" or "WARN" to its value.
+func ParseLevel(name string) (int, bool) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "trace":
+		return TRACE, true
+	case "debug":
+		return DEBUG, true
+	case "info":
+		return INFO, true
+	case "warn", "warning":
+		return WARNING, true
+	case "error":
+		return ERROR, true
+	case "panic":
+		return PANIC, true
+	case "fatal":
+		return FATAL, true
+	case "public":
+		return PUBLIC, true
+	}
+	return 0, false
+}
+
 func init() {
 	logger_default = NewLogger()
 }
@@ -42,11 +92,21 @@ type Record struct {
 	args   []interface{}
 	hight  bool
 	fields []Field
+	name   string
+	raw    bool // trusted message, not escaped
+	// message escaped when it was built, as keys/values and PUBLIC
+	// messages are, so the encoder doesn't escape it again
+	escaped bool
 }
 
 type textEncoder struct {
-	bytes []byte
-	level int
+	bytes  []byte
+	level  int
+	done   chan struct{} // set on the marker queued by Logger.sync
+	stop   bool          // the marker queued by Close
+	escape bool
+	limits Limits
+	rec    *Record // the record encoded in bytes, for structured writers
 }
 
 func (enc *textEncoder) truncate() {
@@ -78,22 +138,97 @@ func (r *Record) Bytes(enc *textEncoder) {
 	if r.level == PUBLIC {
 		meta = "||"
 	}
-	if r.hight {
+	start := len(enc.bytes)
+	if r.hight || len(r.args) == 0 {
 		enc.bytes = append(enc.bytes, r.info...)
-		for _, field := range r.fields {
+	} else {
+		fmt.Fprintf(enc, r.info, r.args...)
+	}
+	if enc.escape && !r.raw && !r.escaped {
+		enc.bytes = escapeTail(enc.bytes, start, 0)
+	}
+	enc.bytes = truncateTail(enc.bytes, start, enc.limits.MaxMessage, enc.escape && !r.raw)
+	sep := byte(' ')
+	if r.level == PUBLIC {
+		sep = '|'
+	}
+	prefix := ""
+	for _, field := range r.fields {
+		if field.fieldType == namespaceType {
+			prefix += field.key + "."
+			continue
+		}
+		enc.bytes = append(enc.bytes, meta...)
+		start = len(enc.bytes)
+		enc.bytes = append(enc.bytes, prefix...)
+		enc.bytes = append(enc.bytes, field.key...)
+		if enc.escape {
+			enc.bytes = escapeTail(enc.bytes, start, sep)
+		}
+		enc.bytes = append(enc.bytes, byte('='))
+		start = len(enc.bytes)
+		var err error
+		enc.bytes, err = field.appendValue(enc.bytes)
+		escaped := enc.escape && field.fieldType != stackType && field.fieldType != rawType
+		if escaped {
+			enc.bytes = escapeTail(enc.bytes, start, sep)
+		}
+		enc.bytes = truncateTail(enc.bytes, start, enc.limits.MaxField, escaped)
+		if err != nil {
 			enc.bytes = append(enc.bytes, meta...)
+			start = len(enc.bytes)
+			enc.bytes = append(enc.bytes, prefix...)
 			enc.bytes = append(enc.bytes, field.key...)
-			enc.bytes = append(enc.bytes, byte('='))
-			enc.bytes = field.WriteValue(enc.bytes)
-		}
-	} else {
-		if len(r.args) == 0 {
-			enc.bytes = append(enc.bytes, r.info...)
-		} else {
-			fmt.Fprintf(enc, r.info, r.args...)
+			enc.bytes = append(enc.bytes, "Error="...)
+			enc.bytes = append(enc.bytes, err.Error()...)
+			if enc.escape {
+				enc.bytes = escapeTail(enc.bytes, start, sep)
+			}
 		}
 	}
+	if r.name != "" {
+		enc.bytes = append(enc.bytes, meta...)
+		enc.bytes = append(enc.bytes, "logger="...)
+		enc.bytes = append(enc.bytes, r.name...)
+	}
 	enc.bytes = append(enc.bytes, '\n')
+	enc.bytes = truncateRecord(enc.bytes, enc.limits.MaxRecord, enc.escape)
+}
+
+// message returns the formatted message of r without fields.
+func (r *Record) message() string {
+	if r.hight || len(r.args) == 0 {
+		return r.info
+	}
+	return fmt.Sprintf(r.info, r.args...)
+}
+
+func (r *Record) Time() time.Time {
+	return r.time
+}
+
+func (r *Record) Level() int {
+	return r.level
+}
+
+// Caller returns the base name of the source file and the line of the log
+// call.
+func (r *Record) Caller() (file string, line int) {
+	return r.code, r.line
+}
+
+// Message returns the formatted message, without fields.
+func (r *Record) Message() string {
+	return r.message()
+}
+
+func (r *Record) Fields() []Field {
+	return r.fields
+}
+
+// LoggerName returns the name of the named logger which wrote r.
+func (r *Record) LoggerName() string {
+	return r.name
 }
 
 type Writer interface {
@@ -114,42 +249,274 @@ type Flusher interface {
 	Flush() error
 }
 
-type Logger struct {
+// Closer is implemented by writers holding files or connections, Close is
+// called once the writer is unregistered or the logger shut down.
+type Closer interface {
+	Close() error
+}
+
+// sink is shared by a logger and every logger Named from it.
+type sink struct {
 	writers []Writer
 	tunnel  chan *textEncoder
-	level   int
-	c       chan bool
 	layout  string
+	root    *Logger
+
+	levelsMu sync.RWMutex
+	levels   map[string]int
+	vmodule  atomic.Value // *vmodule
+
+	noEscape bool
+	limits   Limits
+	redactor *Redactor
+
+	exitFunc    func(code int)
+	fatalStacks bool
+	stackLevel  int
+
+	// mu serializes the writers between the writer goroutine and the
+	// callers of a synchronous logger, which write inline.
+	mu          sync.Mutex
+	synchronous int32 // 1 when set, read by log calls without a lock
+	// fieldReaders counts the registered fieldReaders, read by log calls
+	// without a lock.
+	fieldReaders int32
+
+	// state guards closed against log calls queueing records, which count
+	// themselves in sending so Close can wait for them. closing is closed
+	// first so that none stays blocked on a full tunnel.
+	state     sync.RWMutex
+	closed    bool
+	sending   sync.WaitGroup
+	closing   chan struct{}
+	closeOnce sync.Once
+	closeDone chan struct{}
+	closeErr  error
+	closedOut io.Writer
+
+	errorHandler ErrorHandler // called with mu held
+	errorCounts  [numWriterOps]uint64
+
+	levelCounts [PANIC + 1]uint64
+	dropped     uint64
+	writerStats map[Writer]*writerStat // guarded by mu
+	writerSeq   int
+}
+
+type Logger struct {
+	*sink
+	name  string
+	level int32 // of the root logger, read by log calls without a lock
 }
 
 func NewLogger() *Logger {
 	l := new(Logger)
+	l.sink = new(sink)
 	l.writers = make([]Writer, 0, 2)
 	l.tunnel = make(chan *textEncoder, tunnel_size_default)
-	l.c = make(chan bool, 1)
+	l.closing = make(chan struct{})
+	l.closeDone = make(chan struct{})
+	l.closedOut = os.Stderr
+	l.errorHandler = StderrErrorHandler(os.Stderr)
+	l.writerStats = make(map[Writer]*writerStat)
 	l.level = DEBUG
 	l.layout = "2006-01-02T15:04:05.000+0800"
+	l.root = l
+	l.exitFunc = os.Exit
+	l.stackLevel = levelUnset
 
 	go boostrapLogWriter(l)
 
 	return l
 }
 
+// Named returns a logger sharing the writers of l whose name is name
+// appended to the name of l with a dot. Its level comes from the module
+// levels, see SetModuleLevel.
+func (l *Logger) Named(name string) *Logger {
+	if name == "" {
+		return l
+	}
+	if l.name != "" {
+		name = l.name + "." + name
+	}
+	return &Logger{sink: l.sink, name: name, level: levelUnset}
+}
+
+// Name returns the dotted name of l, empty for a root logger.
+func (l *Logger) Name() string {
+	return l.name
+}
+
+// SetModuleLevel sets the level of the named logger module and of the
+// loggers below it which have no level of their own, so "db" covers
+// "db.pool" unless "db.pool" is set too.
+func (l *Logger) SetModuleLevel(module string, lvl int) {
+	l.levelsMu.Lock()
+	if l.levels == nil {
+		l.levels = make(map[string]int)
+	}
+	l.levels[module] = lvl
+	l.levelsMu.Unlock()
+}
+
+// SetModuleLevels replaces every module level with levels.
+func (l *Logger) SetModuleLevels(levels map[string]int) {
+	m := make(map[string]int, len(levels))
+	for module, lvl := range levels {
+		m[module] = lvl
+	}
+	l.levelsMu.Lock()
+	l.levels = m
+	l.levelsMu.Unlock()
+}
+
+// effectiveLevel resolves the level of a named logger from the closest
+// module level, the root logger level is the default.
+func (l *Logger) effectiveLevel() int {
+	if l.name == "" {
+		return int(atomic.LoadInt32(&l.level))
+	}
+	l.levelsMu.RLock()
+	defer l.levelsMu.RUnlock()
+	module := l.name
+	for {
+		if lvl, ok := l.levels[module]; ok {
+			return lvl
+		}
+		i := strings.LastIndexByte(module, '.')
+		if i < 0 {
+			return int(atomic.LoadInt32(&l.root.level))
+		}
+		module = module[:i]
+	}
+}
+
+func (l *Logger) enabled(level int) bool {
+	return atLeast(level, l.effectiveLevel())
+}
+
+// Register adds w to the writers of l, it may be called while logging. It
+// panics when w fails to initialize, see RegisterErr.
 func (l *Logger) Register(w Writer) {
-	if err := w.Init(); err != nil {
+	if err := l.RegisterErr(w); err != nil {
 		panic(err)
 	}
+}
+
+// RegisterErr is Register returning the error of Init, w is then left out.
+func (l *Logger) RegisterErr(w Writer) error {
+	if err := w.Init(); err != nil {
+		return err
+	}
+	l.mu.Lock()
 	l.writers = append(l.writers, w)
+	l.writerStats[w] = &writerStat{name: writerName(w, l.writerSeq)}
+	l.writerSeq++
+	l.trackFieldReader(w, 1)
+	l.mu.Unlock()
+	return nil
+}
+
+var errNotRegistered = errors.New("writer not registered")
+
+// Unregister removes w once the records logged before the call are
+// written, then flushes and closes it.
+func (l *Logger) Unregister(w Writer) error {
+	l.sync()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	i := l.writerIndex(w)
+	if i < 0 {
+		return errNotRegistered
+	}
+	l.writers = append(l.writers[:i], l.writers[i+1:]...)
+	delete(l.writerStats, w)
+	l.trackFieldReader(w, -1)
+	return l.retireWriter(w)
+}
+
+// Replace puts w in the place of old once the records logged before the
+// call are written to old, then flushes and closes old. w is initialized
+// first and left out if that fails.
+func (l *Logger) Replace(old, w Writer) error {
+	if err := w.Init(); err != nil {
+		return err
+	}
+	l.sync()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	i := l.writerIndex(old)
+	if i < 0 {
+		return errNotRegistered
+	}
+	l.writers[i] = w
+	delete(l.writerStats, old)
+	l.writerStats[w] = &writerStat{name: writerName(w, l.writerSeq)}
+	l.writerSeq++
+	l.trackFieldReader(old, -1)
+	l.trackFieldReader(w, 1)
+	return l.retireWriter(old)
+}
+
+func (l *Logger) writerIndex(w Writer) int {
+	for i, registered := range l.writers {
+		if registered == w {
+			return i
+		}
+	}
+	return -1
+}
+
+// retireWriter flushes and closes a writer taken out of l, l.mu held.
+func (l *Logger) retireWriter(w Writer) error {
+	var err error
+	if f, ok := w.(Flusher); ok {
+		if err = f.Flush(); err != nil {
+			l.handleError(w, OpFlush, err)
+		}
+	}
+	if c, ok := w.(Closer); ok {
+		if cerr := c.Close(); cerr != nil {
+			l.handleError(w, OpClose, cerr)
+			if err == nil {
+				err = cerr
+			}
+		}
+	}
+	return err
 }
 
 func (l *Logger) SetLevel(lvl int) {
-	l.level = lvl
+	if l.name != "" {
+		l.SetModuleLevel(l.name, lvl)
+		return
+	}
+	atomic.StoreInt32(&l.level, int32(lvl))
 }
 
 func (l *Logger) SetLayout(layout string) {
 	l.layout = layout
 }
 
+// SetExitFunc replaces os.Exit as the function Fatal calls once the
+// record is written.
+func (l *Logger) SetExitFunc(exit func(code int)) {
+	l.exitFunc = exit
+}
+
+// SetStacktraceLevel attaches a "stacktrace" field to records at or above
+// lvl, PUBLIC records excepted. A negative lvl turns it off.
+func (l *Logger) SetStacktraceLevel(lvl int) {
+	l.stackLevel = lvl
+}
+
+// SetFatalStackDump makes Fatal write the stacks of all goroutines as a
+// FATAL record before exiting.
+func (l *Logger) SetFatalStackDump(on bool) {
+	l.fatalStacks = on
+}
+
 func (l *Logger) Public(fmt string, args ...interface{}) {
 	l.deliverRecordToWriter(PUBLIC, fmt, args...)
 }
@@ -174,26 +541,109 @@ func (l *Logger) Error(fmt string, args ...interface{}) {
 	l.deliverRecordToWriter(ERROR, fmt, args...)
 }
 
+// Fatal writes the record, flushes every writer and calls the exit
+// function, os.Exit(1) by default.
 func (l *Logger) Fatal(fmt string, args ...interface{}) {
 	l.deliverRecordToWriter(FATAL, fmt, args...)
 }
 
-func (l *Logger) close() {
-	close(l.tunnel)
-	<-l.c
+// Panic writes the record, flushes every writer and panics with the
+// message.
+func (l *Logger) Panic(fmt string, args ...interface{}) {
+	l.deliverRecordToWriter(PANIC, fmt, args...)
+}
+
+// HighTrace and the following write msg with structured fields.
+func (l *Logger) HighTrace(msg string, fields ...Field) {
+	l.deliverRecordToWriterHight(TRACE, msg, fields...)
+}
+
+func (l *Logger) HighDebug(msg string, fields ...Field) {
+	l.deliverRecordToWriterHight(DEBUG, msg, fields...)
+}
+
+func (l *Logger) HighInfo(msg string, fields ...Field) {
+	l.deliverRecordToWriterHight(INFO, msg, fields...)
+}
+
+func (l *Logger) HighWarn(msg string, fields ...Field) {
+	l.deliverRecordToWriterHight(WARNING, msg, fields...)
+}
+
+func (l *Logger) HighError(msg string, fields ...Field) {
+	l.deliverRecordToWriterHight(ERROR, msg, fields...)
+}
+
+func (l *Logger) HighPanic(msg string, fields ...Field) {
+	l.deliverRecordToWriterHight(PANIC, msg, fields...)
+}
+
+func (l *Logger) HighFatal(msg string, fields ...Field) {
+	l.deliverRecordToWriterHight(FATAL, msg, fields...)
+}
 
+func (l *Logger) flush() {
 	for _, w := range l.writers {
 		if f, ok := w.(Flusher); ok {
 			if err := f.Flush(); err != nil {
-				log.Println(err)
+				l.handleError(w, OpFlush, err)
 			}
 		}
 	}
+}
+
+// Sync blocks until every record logged before the call is written and
+// the writers are flushed. Unlike Close the logger stays usable.
+func (l *Logger) Sync() {
+	l.sync()
+}
+
+// SetSynchronous makes log calls write and flush the record before they
+// return instead of queueing it for the writer goroutine, so nothing is
+// lost to a crash or os.Exit at the cost of latency. It applies to the
+// loggers named from l too.
+func (l *Logger) SetSynchronous(on bool) {
+	if !on {
+		atomic.StoreInt32(&l.synchronous, 0)
+		return
+	}
+	if atomic.LoadInt32(&l.synchronous) == 0 {
+		l.sync()
+	}
+	atomic.StoreInt32(&l.synchronous, 1)
+}
+
+// sync waits until the writer goroutine has written every record queued
+// before the call and flushed the writers.
+func (l *Logger) sync() {
+	if !l.startSending() {
+		return
+	}
+	done := make(chan struct{})
+	select {
+	case l.tunnel <- &textEncoder{done: done}:
+		l.sending.Done()
+		<-done
+	case <-l.closing:
+		l.sending.Done()
+	}
+}
 
+// startSending counts a log call in sending unless l is closed. The call
+// must then end with sending.Done.
+func (l *Logger) startSending() bool {
+	l.state.RLock()
+	defer l.state.RUnlock()
+	if l.closed {
+		return false
+	}
+	l.sending.Add(1)
+	return true
 }
 
 func (l *Logger) deliverRecordToWriter(level int, format string, args ...interface{}) {
-	if level < l.level {
+	if !l.enabledAt(level, 2) {
+		l.terminate(&Record{level: level, info: format, args: args})
 		return
 	}
 	// source code, file and line num
@@ -208,18 +658,127 @@ func (l *Logger) deliverRecordToWriter(level int, format string, args ...interfa
 	r.time = time.Now()
 	r.level = level
 	r.args = args
+	r.name = l.name
+
+	l.output(r)
+}
 
+// output encodes r and hands it to the writer goroutine, PANIC and FATAL
+// records are flushed before panicking or exiting, see terminate.
+func (l *Logger) output(r *Record) {
+	if !l.noEscape && r.level == PUBLIC && !r.hight && len(r.args) > 0 {
+		r.info = escapeString(r.info, 0)
+		r.args = escapeArgs(r.args)
+		r.escaped = true
+	}
+	// format now, writers may look at the record after the caller went on
+	r.info = r.message()
+	r.args = nil
+	if atomic.LoadInt32(&l.fieldReaders) > 0 {
+		r.fields = snapshotFields(r.fields)
+	}
+	atomic.AddUint64(&l.levelCounts[r.level], 1)
+	if l.stackLevel >= 0 && atLeast(r.level, l.stackLevel) && r.level != PUBLIC {
+		n := len(r.fields)
+		r.fields = append(r.fields[:n:n], Stack("stacktrace"))
+	}
+	if l.redactor != nil {
+		l.redactor.redact(r)
+	}
+	l.emit(l.encode(r))
+
+	if r.level == FATAL && l.fatalStacks {
+		dump := &Record{
+			time:  time.Now(),
+			code:  r.code,
+			line:  r.line,
+			info:  "goroutine stacks:\n" + string(allStacks()),
+			level: FATAL,
+			name:  r.name,
+			raw:   true,
+		}
+		if l.redactor != nil {
+			l.redactor.redact(dump)
+		}
+		l.emit(l.encode(dump))
+	}
+	l.terminate(r)
+}
+
+// terminate panics after a PANIC record and exits after a FATAL one once
+// the writers are flushed, whether or not the level let the record through.
+func (l *Logger) terminate(r *Record) {
+	switch r.level {
+	case PANIC:
+		l.sync()
+		panic(r.message())
+	case FATAL:
+		l.sync()
+		l.exitFunc(1)
+	}
+}
+
+// emit writes and flushes enc inline for a synchronous logger, else queues
+// it for the writer goroutine. Once l is closed it goes to the closed
+// output.
+func (l *Logger) emit(enc *textEncoder) {
+	if !l.startSending() {
+		l.writeClosed(enc)
+		return
+	}
+	defer l.sending.Done()
+	if atomic.LoadInt32(&l.synchronous) == 1 {
+		l.mu.Lock()
+		l.write(enc)
+		l.flush()
+		l.mu.Unlock()
+		return
+	}
+	select {
+	case l.tunnel <- enc:
+	case <-l.closing:
+		l.writeClosed(enc)
+	}
+}
+
+// writeClosed writes a record logged during or after Close to the closed
+// output.
+func (l *Logger) writeClosed(enc *textEncoder) {
+	if out := l.closedOut; out != nil {
+		out.Write(enc.bytes)
+	} else {
+		atomic.AddUint64(&l.dropped, 1)
+	}
+	putTextEncoder(enc)
+}
+
+func (l *Logger) encode(r *Record) *textEncoder {
 	enc := textPool.Get().(*textEncoder)
 	enc.truncate() // 为啥这里需要truncate
 	enc.level = r.level
+	enc.escape = !l.noEscape
+	enc.limits = l.limits
+	enc.rec = r
 	r.Bytes(enc)
+	return enc
+}
 
-	l.tunnel <- enc
+// allStacks returns the stack traces of all goroutines.
+func allStacks() []byte {
+	buf := make([]byte, 64<<10)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			return buf[:n]
+		}
+		buf = make([]byte, 2*len(buf))
+	}
 }
 
 func (l *Logger) deliverRecordToWriterHight(level int, with string,
 	fields ...Field) {
-	if level < l.level {
+	if !l.enabledAt(level, 2) {
+		l.terminate(&Record{level: level, info: with, hight: true})
 		return
 	}
 	// source code,file and line num
@@ -234,13 +793,9 @@ func (l *Logger) deliverRecordToWriterHight(level int, with string,
 	r.time = time.Now()
 	r.level = level
 	r.fields = fields
+	r.name = l.name
 
-	enc := textPool.Get().(*textEncoder)
-	enc.truncate()
-	enc.level = r.level
-	r.Bytes(enc)
-
-	l.tunnel <- enc
+	l.output(r)
 }
 
 func boostrapLogWriter(logger *Logger) {
@@ -249,81 +804,112 @@ func boostrapLogWriter(logger *Logger) {
 	}
 
 	var (
-		enc *textEncoder
-		ok  bool
+		enc  *textEncoder
+		stop bool
 	)
 
-	if enc, ok = <-logger.tunnel; !ok {
-		logger.c <- true
+	enc = <-logger.tunnel
+	logger.mu.Lock()
+	stop = logger.write(enc)
+	logger.mu.Unlock()
+	if stop {
 		return
 	}
 
-	for _, w := range logger.writers {
-		if err := w.Write(enc); err != nil {
-			log.Println(err)
-		}
-	}
-
 	flushTimer := time.NewTimer(time.Millisecond * 500)
 	rotateTimer := time.NewTimer(time.Second * 10)
 	deleteTimer := time.NewTimer(time.Hour)
 
 	for {
 		select {
-		case enc, ok = <-logger.tunnel:
-			if !ok {
-				logger.c <- true
+		case enc = <-logger.tunnel:
+			logger.mu.Lock()
+			stop = logger.write(enc)
+			logger.mu.Unlock()
+			if stop {
+				flushTimer.Stop()
+				rotateTimer.Stop()
+				deleteTimer.Stop()
 				return
 			}
 
-			for _, w := range logger.writers {
-				if err := w.Write(enc); err != nil {
-					log.Println(err)
-				}
-			}
-			textPool.Put(enc)
-
 		case <-flushTimer.C:
-			for _, w := range logger.writers {
-				if f, ok := w.(Flusher); ok {
-					if err := f.Flush(); err != nil {
-						log.Println(err)
-					}
-				}
-			}
+			logger.mu.Lock()
+			logger.flush()
+			logger.mu.Unlock()
 			flushTimer.Reset(time.Millisecond * 1000)
 
 		case <-rotateTimer.C:
+			logger.mu.Lock()
 			for _, w := range logger.writers {
 				if r, ok := w.(Rotater); ok {
 					if err := r.Rotate(); err != nil {
-						log.Println(err)
+						logger.handleError(w, OpRotate, err)
 					}
 				}
 			}
+			logger.mu.Unlock()
 			rotateTimer.Reset(time.Second* 10)
 
 		case <-deleteTimer.C:
+			logger.mu.Lock()
 			for _, w := range logger.writers {
 				if d, ok := w.(Deleter); ok {
 					// delete expired file logic
 					if err := d.Delete(); err != nil {
-						log.Println(err) // have err but ok
+						logger.handleError(w, OpDelete, err)
 					}
 				}
 			}
+			logger.mu.Unlock()
 			deleteTimer.Reset(time.Hour)
 		}
 	}
 }
 
+// write hands enc to every writer, a sync marker flushes them instead and
+// releases its waiter. The marker of Close closes them too and stops the
+// writer goroutine.
+func (l *Logger) write(enc *textEncoder) (stop bool) {
+	if enc.done != nil {
+		l.flush()
+		if enc.stop {
+			l.closeErr = l.closeWriters()
+		}
+		close(enc.done)
+		return enc.stop
+	}
+	for _, w := range l.writers {
+		if err := w.Write(enc); err != nil {
+			l.handleError(w, OpWrite, err)
+		} else {
+			l.countWrite(w, enc)
+		}
+	}
+	putTextEncoder(enc)
+	return false
+}
+
 // default
 var (
 	logger_default *Logger
 )
 
 func SetLevel(lvl int) {
-	logger_default.level = lvl
+	logger_default.SetLevel(lvl)
+}
+
+// Named returns a logger of the default logger, see Logger.Named.
+func Named(name string) *Logger {
+	return logger_default.Named(name)
+}
+
+func SetModuleLevel(module string, lvl int) {
+	logger_default.SetModuleLevel(module, lvl)
+}
+
+func SetModuleLevels(levels map[string]int) {
+	logger_default.SetModuleLevels(levels)
 }
 
 func SetLayout(layout string) {
@@ -358,6 +944,30 @@ func Fatal(fmt string, args ...interface{}) {
 	logger_default.deliverRecordToWriter(FATAL, fmt, args...)
 }
 
+func Panic(fmt string, args ...interface{}) {
+	logger_default.deliverRecordToWriter(PANIC, fmt, args...)
+}
+
+func SetExitFunc(exit func(code int)) {
+	logger_default.SetExitFunc(exit)
+}
+
+func SetStacktraceLevel(lvl int) {
+	logger_default.SetStacktraceLevel(lvl)
+}
+
+func SetFatalStackDump(on bool) {
+	logger_default.SetFatalStackDump(on)
+}
+
+func Sync() {
+	logger_default.Sync()
+}
+
+func SetSynchronous(on bool) {
+	logger_default.SetSynchronous(on)
+}
+
 func HighTrace(fmt string, fields ...Field) {
 	logger_default.deliverRecordToWriterHight(TRACE, fmt, fields...)
 }
@@ -366,6 +976,10 @@ func HighDebug(fmt string, fields ...Field) {
 	logger_default.deliverRecordToWriterHight(DEBUG, fmt, fields...)
 }
 
+func HighPanic(fmt string, fields ...Field) {
+	logger_default.deliverRecordToWriterHight(PANIC, fmt, fields...)
+}
+
 func HighWarn(fmt string, fields ...interface{}) {
 	logger_default.deliverRecordToWriter(WARNING, fmt, fields...)
 }
@@ -386,11 +1000,25 @@ func Register(w Writer) {
 	logger_default.Register(w)
 }
 
+func RegisterErr(w Writer) error {
+	return logger_default.RegisterErr(w)
+}
+
+func Unregister(w Writer) error {
+	return logger_default.Unregister(w)
+}
+
+func Replace(old, w Writer) error {
+	return logger_default.Replace(old, w)
+}
+
 func (l *Logger) RegisterWithFile(filepath, rotateLogPath string, level int) {
 	w := NewFileWriter()
 	w.SetFileName(filepath)
-	err := w.SetPathPattern(rotateLogPath)
-	fmt.Printf("%+v\n", err)
+	if err := w.SetPathPattern(rotateLogPath); err != nil {
+		// the file is still written, only not rotated
+		fmt.Fprintln(os.Stderr, "clog:", err)
+	}
 	w.SetLogLevelFloor(level)
 	l.Register(w)
 }
@@ -401,8 +1029,8 @@ func NewLoggerWithFile(filepath, rotateLogPath string, level int) *Logger {
 	return l
 }
 
-func Close() {
-	logger_default.close()
+func Close() error {
+	return logger_default.Close()
 }
 
 
@@ -412,11 +1040,11 @@ func (l *Logger) formatSliceMsg(keys []string,
 	value []interface{}) (str string) {
 	splitstr := "||"
 	if len(keys) != len(value) {
-		return fmt.Sprintf(" keys=%v"+splitstr+"values=%v", keys, value)
+		return l.escapeSort(fmt.Sprintf(" keys=%v"+splitstr+"values=%v", keys, value))
 	}
 	str = splitstr
 	for index, v := range keys {
-		str += v + "=" + fmt.Sprintf("%+v", value[index]) + splitstr
+		str += l.formatKey(v) + "=" + l.formatValue(v, value[index]) + splitstr
 	}
 	str = strings.TrimRight(str, splitstr)
 	str = strings.TrimLeft(str, splitstr)
@@ -424,60 +1052,74 @@ func (l *Logger) formatSliceMsg(keys []string,
 	return
 }
 
-func (l *Logger) TraceSort(keys []string, value []interface{}) {
-	if TRACE < l.level {
-		return
+// formatValue prints val of a keys/values call, redacted when l has a
+// Redactor and with '|' escaped so it cannot add a pair.
+func (l *Logger) formatValue(key string, val interface{}) string {
+	var s string
+	if l.redactor != nil {
+		s = l.redactor.value(key, val)
+	} else {
+		s = fmt.Sprintf("%+v", val)
 	}
-	msg := l.formatSliceMsg(keys, value)
-	l.writeMsg(TRACE, msg)
+	return l.escapeSort(s)
 }
 
-func (l *Logger) DebugSort(keys []string, value []interface{}) {
-	if DEBUG < l.level {
-		return
+func (l *Logger) formatKey(key string) string {
+	return l.escapeSort(key)
+}
+
+// escapeSort escapes a part of a keys/values message, whose pairs are
+// split on "||". writeMsg marks the message as escaped.
+func (l *Logger) escapeSort(s string) string {
+	if l.noEscape {
+		return s
 	}
-	msg := l.formatSliceMsg(keys, value)
-	l.writeMsg(DEBUG, msg)
+	return escapeString(s, '|')
+}
+
+func (l *Logger) TraceSort(keys []string, value []interface{}) {
+	l.writeSort(TRACE, keys, value)
+}
+
+func (l *Logger) DebugSort(keys []string, value []interface{}) {
+	l.writeSort(DEBUG, keys, value)
 }
 
 func (l *Logger) InfoSort(keys []string, value []interface{}) {
-	if INFO < l.level {
-		return
-	}
-	msg := l.formatSliceMsg(keys, value)
-	l.writeMsg(INFO, msg)
+	l.writeSort(INFO, keys, value)
 }
 
 func (l *Logger) WarningSort(keys []string, value []interface{}) {
-	if WARNING < l.level {
-		return
-	}
-	msg := l.formatSliceMsg(keys, value)
-	l.writeMsg(WARNING, msg)
+	l.writeSort(WARNING, keys, value)
 }
 
 func (l *Logger) ErrorSort(keys []string, value []interface{}) {
-	if ERROR < l.level {
-		return
-	}
-	msg := l.formatSliceMsg(keys, value)
-	l.writeMsg(ERROR, msg)
+	l.writeSort(ERROR, keys, value)
 }
 
 func (l *Logger) FatalSort(keys []string, value []interface{}) {
-	if FATAL < l.level {
-		return
-	}
-	msg := l.formatSliceMsg(keys, value)
-	l.writeMsg(FATAL, msg)
+	l.writeSort(FATAL, keys, value)
+}
+
+func (l *Logger) PanicSort(keys []string, value []interface{}) {
+	l.writeSort(PANIC, keys, value)
 }
 
 func (l *Logger) PublicSort(keys []string, value []interface{}) {
-	if PUBLIC < l.level {
+	l.writeSort(PUBLIC, keys, value)
+}
+
+// writeSort is called right from every exported keys/values function,
+// so their caller is always 2 frames up for the vmodule rules and 3 for
+// writeMsg.
+func (l *Logger) writeSort(level int, keys []string, value []interface{}) {
+	if !l.enabledAt(level, 2) {
+		if level == PANIC || level == FATAL {
+			l.terminate(&Record{level: level, info: l.formatSliceMsg(keys, value)})
+		}
 		return
 	}
-	msg := l.formatSliceMsg(keys, value)
-	l.writeMsg(PUBLIC, msg)
+	l.writeMsg(level, l.formatSliceMsg(keys, value))
 }
 
 //
@@ -493,83 +1135,84 @@ func (l *Logger) writeMsg(level int, str string) {
 	}
 	r.time = time.Now()
 	r.level = level
-	enc := textPool.Get().(*textEncoder)
-	enc.truncate()
-	enc.level = r.level
-	r.Bytes(enc)
+	r.name = l.name
+	r.escaped = !l.noEscape
 
-	l.tunnel <- enc
-	return
+	l.output(r)
 }
 
 func ListTrace(keys []string, value []interface{}) {
-	logger_default.TraceSort(keys, value)
+	logger_default.writeSort(TRACE, keys, value)
 }
 
 func ListDebug(keys []string, value []interface{}) {
-	logger_default.DebugSort(keys, value)
+	logger_default.writeSort(DEBUG, keys, value)
 }
 
 func ListInfo(keys []string, value []interface{}) {
-	logger_default.InfoSort(keys, value)
+	logger_default.writeSort(INFO, keys, value)
 }
 
 func ListWarning(keys []string, value []interface{}) {
-	logger_default.WarningSort(keys, value)
+	logger_default.writeSort(WARNING, keys, value)
 }
 
 func ListError(keys []string, value []interface{}) {
-	logger_default.ErrorSort(keys, value)
+	logger_default.writeSort(ERROR, keys, value)
 }
 
 func ListFatal(keys []string, value []interface{}) {
-	logger_default.FatalSort(keys, value)
+	logger_default.writeSort(FATAL, keys, value)
+}
+
+func ListPanic(keys []string, value []interface{}) {
+	logger_default.writeSort(PANIC, keys, value)
 }
 
 func ListPublic(keys []string, value []interface{}) {
-	logger_default.PublicSort(keys, value)
+	logger_default.writeSort(PUBLIC, keys, value)
 }
 
 func TraceTrace(trace_id string, keys []string, value []interface{}) {
 	keys = append(keys, "trace_id")
 	value = append(value, trace_id)
-	logger_default.TraceSort(keys, value)
+	logger_default.writeSort(TRACE, keys, value)
 }
 
 func TraceDebug(trace_id string, keys []string, value []interface{}) {
 	keys = append(keys, "trace_id")
 	value = append(value, trace_id)
-	logger_default.DebugSort(keys, value)
+	logger_default.writeSort(DEBUG, keys, value)
 }
 
 func TraceInfo(trace_id string, keys []string, value []interface{}) {
 	keys = append(keys, "trace_id")
 	value = append(value, trace_id)
-	logger_default.InfoSort(keys, value)
+	logger_default.writeSort(INFO, keys, value)
 }
 
 func TraceError(trace_id string, keys []string, value []interface{}) {
 	keys = append(keys, "trace_id")
 	value = append(value, trace_id)
-	logger_default.ErrorSort(keys, value)
+	logger_default.writeSort(ERROR, keys, value)
 }
 
 func TraceWarning(trace_id string, keys []string, value []interface{}) {
 	keys = append(keys, "trace_id")
 	value = append(value, trace_id)
-	logger_default.WarningSort(keys, value)
+	logger_default.writeSort(WARNING, keys, value)
 }
 
 func TraceFatal(trace_id string, keys []string, value []interface{}) {
 	keys = append(keys, "trace_id")
 	value = append(value, trace_id)
-	logger_default.FatalSort(keys, value)
+	logger_default.writeSort(FATAL, keys, value)
 }
 
 func TracePublic(trace_id string, keys []string, value []interface{}) {
 	keys = append(keys, "trace_id")
 	value = append(value, trace_id)
-	logger_default.PublicSort(keys, value)
+	logger_default.writeSort(PUBLIC, keys, value)
 }
 
 //LoggerContext package
@@ -577,15 +1220,101 @@ type LoggerContext struct {
 	lg     *Logger
 	logID  string
 	detail map[string]interface{}
+	level  int
+}
+
+// levelUnset marks a LoggerContext that follows the level of its logger.
+const levelUnset = -1
+
+// LevelHeader is the request header read by SetLevelFromHeader.
+const LevelHeader = "X-Clog-Level"
+
+// LevelRule picks a level for a LoggerContext from its log id and details,
+// ok reports whether the rule matched.
+type LevelRule func(logID string, detail map[string]interface{}) (level int, ok bool)
+
+var (
+	levelRulesMu sync.RWMutex
+	levelRules   []LevelRule
+)
+
+// AddLevelRule registers a rule consulted by every new LoggerContext and
+// whenever a detail is set on one. The most verbose matching level wins.
+func AddLevelRule(rule LevelRule) {
+	levelRulesMu.Lock()
+	levelRules = append(levelRules, rule)
+	levelRulesMu.Unlock()
+}
+
+// ResetLevelRules drops every registered LevelRule.
+func ResetLevelRules() {
+	levelRulesMu.Lock()
+	levelRules = nil
+	levelRulesMu.Unlock()
 }
 
 //NewLoggerContext 初始化一个上线访问日志
 func NewLoggerContext(logid string) *LoggerContext {
-	return &LoggerContext{
+	lc := &LoggerContext{
 		lg:     logger_default,
 		logID:  logid,
 		detail: make(map[string]interface{}),
+		level:  levelUnset,
+	}
+	lc.applyLevelRules()
+	return lc
+}
+
+// SetLevel overrides the logger level for this context only.
+func (lc *LoggerContext) SetLevel(lvl int) {
+	lc.level = lvl
+}
+
+// SetLevelFromHeader applies the level named by the LevelHeader of h and
+// reports whether it did. A header may only make the context more verbose
+// than its logger, so a client can't silence the errors of its requests.
+func (lc *LoggerContext) SetLevelFromHeader(h http.Header) bool {
+	lvl, ok := ParseLevel(h.Get(LevelHeader))
+	if !ok || atLeast(lvl, lc.lg.effectiveLevel()) {
+		return false
+	}
+	lc.lower(lvl)
+	return true
+}
+
+// SetDetail attaches key=val to every line of this context.
+func (lc *LoggerContext) SetDetail(key string, val interface{}) {
+	lc.detail[key] = val
+	lc.applyLevelRules()
+}
+
+func (lc *LoggerContext) applyLevelRules() {
+	levelRulesMu.RLock()
+	defer levelRulesMu.RUnlock()
+	for _, rule := range levelRules {
+		lvl, ok := rule(lc.logID, lc.detail)
+		if !ok {
+			continue
+		}
+		lc.lower(lvl)
+	}
+}
+
+// lower sets the level of the context to lvl unless it is already more
+// verbose.
+func (lc *LoggerContext) lower(lvl int) {
+	if lc.level == levelUnset || !atLeast(lvl, lc.level) {
+		lc.level = lvl
+	}
+}
+
+// enabled checks level against the context override, falling back to the
+// level of the underlying logger.
+func (lc *LoggerContext) enabled(level int) bool {
+	if lc.level != levelUnset {
+		return atLeast(level, lc.level)
 	}
+	return lc.lg.enabledAt(level, 2)
 }
 
 //fmt log
@@ -603,7 +1332,7 @@ func (lc *LoggerContext) msgFormat(level int, keys []string,
 	}
 	str = splitstr
 	for index, v := range keys {
-		str += v + "= " + fmt.Sprintf("%+v", values[index]) + splitstr
+		str += lc.lg.formatKey(v) + "= " + lc.lg.formatValue(v, values[index]) + splitstr
 	}
 	str = strings.TrimRight(str, splitstr)
 	str = strings.TrimLeft(str,splitstr)
@@ -614,21 +1343,21 @@ func (lc *LoggerContext) msgFormat(level int, keys []string,
 
 //
 func (lc *LoggerContext) LogInfo(keys []string, value []interface{}) {
-	if INFO < lc.lg.level { // level 是固定的，不能改变
+	if !lc.enabled(INFO) {
 		return
 	}
 	lc.msgFormat(INFO, keys, value)
 }
 
 func (lc *LoggerContext) LogError(keys []string, value []interface{}) {
-	if ERROR < lc.lg.level {
+	if !lc.enabled(ERROR) {
 		return
 	}
 	lc.msgFormat(ERROR, keys, value)
 }
 
 func (lc *LoggerContext) LogDebug(keys []string, value []interface{}) {
-	if DEBUG < lc.lg.level {
+	if !lc.enabled(DEBUG) {
 		return
 	}
 	lc.msgFormat(DEBUG, keys, value)
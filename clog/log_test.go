@@ -0,0 +1,290 @@
+package clog
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// render encodes a record with fields as the text and JSON writers see it.
+func render(msg string, fields ...Field) (text, json string) {
+	r := &Record{
+		time:   time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC),
+		code:   "x.go",
+		line:   7,
+		info:   msg,
+		level:  INFO,
+		hight:  true,
+		fields: fields,
+	}
+	enc := &textEncoder{escape: true}
+	r.Bytes(enc)
+	text = string(enc.bytes)
+	enc.bytes = enc.bytes[:0]
+	r.JSON(enc)
+	return text, string(enc.bytes)
+}
+
+func TestRegisterAtRuntime(t *testing.T) {
+	l := NewLogger()
+	defer l.Close()
+	first := NewObserver(TRACE)
+	l.Register(first)
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				l.Info("busy")
+			}
+		}
+	}()
+
+	debug := NewObserver(TRACE)
+	l.Register(debug)
+	l.Info("incident")
+	if err := l.Unregister(debug); err != nil {
+		t.Fatal(err)
+	}
+	if len(debug.FilterMessage("incident")) != 1 {
+		t.Fatal("record logged before Unregister is missing")
+	}
+	n := debug.Len()
+	second := NewObserver(TRACE)
+	if err := l.Replace(first, second); err != nil {
+		t.Fatal(err)
+	}
+	close(stop)
+	wg.Wait()
+	l.Info("done")
+	l.Sync()
+
+	if debug.Len() != n {
+		t.Fatal("unregistered writer still written to")
+	}
+	if len(first.FilterMessage("done")) != 0 || len(second.FilterMessage("done")) != 1 {
+		t.Fatal("writer not replaced")
+	}
+	if err := l.Unregister(debug); err != errNotRegistered {
+		t.Fatalf("got %v", err)
+	}
+}
+
+func TestBuiltinWritersClose(t *testing.T) {
+	writers := []Writer{
+		NewFileWriter(),
+		NewConsoleWriter(),
+		NewSyslogWriter("", "", "test"),
+		NewNetWriter("tcp", "127.0.0.1:1"),
+		NewHTTPWriter("http://127.0.0.1:1", JSONArrayPayload{}),
+		NewJournaldWriter("test"),
+		NewRingWriter(0),
+		NewAlertWriter("http://127.0.0.1:1"),
+	}
+	for _, w := range writers {
+		c, ok := w.(Closer)
+		if !ok {
+			t.Fatalf("%T is no Closer", w)
+		}
+		// closing a writer never initialized, or twice, is fine
+		if err := c.Close(); err != nil {
+			t.Fatalf("%T: %v", w, err)
+		}
+		if err := c.Close(); err != nil {
+			t.Fatalf("%T closed twice: %v", w, err)
+		}
+	}
+}
+
+func TestLoggerContextLevelFromHeader(t *testing.T) {
+	l := NewLogger()
+	l.SetLevel(INFO)
+	for _, c := range []struct {
+		header string
+		ok     bool
+		level  int
+	}{
+		{"debug", true, DEBUG},
+		{"trace", true, TRACE},
+		{"info", false, levelUnset},
+		{"error", false, levelUnset},
+		{"panic", false, levelUnset},
+		{"loud", false, levelUnset},
+	} {
+		lc := &LoggerContext{lg: l, detail: map[string]interface{}{}, level: levelUnset}
+		h := http.Header{}
+		h.Set(LevelHeader, c.header)
+		if ok := lc.SetLevelFromHeader(h); ok != c.ok || lc.level != c.level {
+			t.Errorf("header %q: ok %v, level %d", c.header, ok, lc.level)
+		}
+	}
+}
+
+func TestLoggerContextLevelRules(t *testing.T) {
+	defer ResetLevelRules()
+	AddLevelRule(func(logID string, _ map[string]interface{}) (int, bool) {
+		return FATAL, logID == "quiet"
+	})
+	AddLevelRule(func(logID string, _ map[string]interface{}) (int, bool) {
+		return PANIC, logID == "quiet"
+	})
+	AddLevelRule(func(_ string, detail map[string]interface{}) (int, bool) {
+		return DEBUG, detail["user"] == "alice"
+	})
+	l := NewLogger()
+	lc := &LoggerContext{lg: l, logID: "quiet", detail: map[string]interface{}{}, level: levelUnset}
+
+	// PANIC ranks below FATAL, so it is the more verbose of the two
+	lc.applyLevelRules()
+	if lc.level != PANIC || !lc.enabled(PANIC) || lc.enabled(ERROR) {
+		t.Fatalf("level %d after the log id rules", lc.level)
+	}
+	lc.SetDetail("user", "alice")
+	if lc.level != DEBUG {
+		t.Fatalf("level %d after the detail rule", lc.level)
+	}
+	lc.SetDetail("user", "bob")
+	if lc.level != DEBUG {
+		t.Fatalf("level %d raised by a later detail", lc.level)
+	}
+}
+
+func TestNamedLoggers(t *testing.T) {
+	l := NewLogger()
+	l.SetLevel(INFO)
+	pool := l.Named("db").Named("pool")
+	if pool.Name() != "db.pool" || l.Named("") != l {
+		t.Fatalf("name %q", pool.Name())
+	}
+	cache := l.Named("cache")
+
+	for _, c := range []struct {
+		set     func()
+		logger  *Logger
+		level   int
+		enabled bool
+	}{
+		{func() {}, pool, DEBUG, false},
+		{func() { l.SetModuleLevel("db", DEBUG) }, pool, DEBUG, true},
+		{func() {}, cache, DEBUG, false},
+		{func() { l.SetModuleLevel("db.pool", WARNING) }, pool, INFO, false},
+		{func() {}, pool.Named("conn"), INFO, false},
+		{func() {}, l.Named("db"), DEBUG, true},
+		{func() { cache.SetLevel(TRACE) }, cache, TRACE, true},
+		{func() { l.SetModuleLevels(map[string]int{"cache": ERROR}) }, cache, WARNING, false},
+		{func() {}, pool, DEBUG, false},
+		{func() {}, pool, INFO, true},
+	} {
+		c.set()
+		if c.logger.enabled(c.level) != c.enabled {
+			t.Fatalf("%s at %s: enabled %v", c.logger.Name(), LEVEL_FLAGS[c.level], !c.enabled)
+		}
+	}
+}
+
+func TestSetLevelWhileNamedLogging(t *testing.T) {
+	l := NewLogger()
+	db := l.Named("db")
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			db.Debug("busy")
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		l.SetLevel(INFO + i%2)
+	}
+	<-done
+}
+
+func TestNamedLoggerField(t *testing.T) {
+	r := &Record{level: INFO, info: "m", hight: true, name: "db.pool"}
+	enc := &textEncoder{escape: true}
+	r.Bytes(enc)
+	if !strings.HasSuffix(string(enc.bytes), "] m logger=db.pool\n") {
+		t.Fatalf("text %q", enc.bytes)
+	}
+	enc.bytes = enc.bytes[:0]
+	r.JSON(enc)
+	if !strings.Contains(string(enc.bytes), `"logger":"db.pool"`) {
+		t.Fatalf("json %q", enc.bytes)
+	}
+}
+
+func TestFatalAndPanicBelowLevel(t *testing.T) {
+	l := NewLogger()
+	l.SetLevel(PUBLIC)
+	code := -1
+	l.SetExitFunc(func(c int) { code = c })
+
+	l.Fatal("filtered %d", 1)
+	if code != 1 {
+		t.Fatalf("Fatal below the level exited with %d", code)
+	}
+	code = -1
+	l.HighFatal("filtered")
+	if code != 1 {
+		t.Fatalf("HighFatal below the level exited with %d", code)
+	}
+	code = -1
+	l.FatalSort([]string{"k"}, []interface{}{"v"})
+	if code != 1 {
+		t.Fatalf("FatalSort below the level exited with %d", code)
+	}
+
+	db := l.Named("db")
+	l.SetModuleLevel("db", PUBLIC)
+	defer func() {
+		if r := recover(); r != "filtered 2" {
+			t.Fatalf("Panic below the level recovered %v", r)
+		}
+	}()
+	db.Panic("filtered %d", 2)
+	t.Fatal("Panic below the level returned")
+}
+
+func TestPanicSortBelowLevel(t *testing.T) {
+	l := NewLogger()
+	l.SetLevel(PUBLIC)
+	defer func() {
+		if r := recover(); r != "=> k=v||n=2" {
+			t.Fatalf("PanicSort below the level recovered %q", r)
+		}
+	}()
+	l.PanicSort([]string{"k", "n"}, []interface{}{"v", 2})
+	t.Fatal("PanicSort below the level returned")
+}
+
+func TestSetSynchronousWhileLogging(t *testing.T) {
+	l := NewLogger()
+	o := NewObserver(TRACE)
+	l.Register(o)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				l.Info("busy")
+			}
+		}()
+	}
+	for i := 0; i < 10; i++ {
+		l.SetSynchronous(i%2 == 0)
+	}
+	wg.Wait()
+	l.Sync()
+	if o.Len() != 400 {
+		t.Fatalf("got %d records", o.Len())
+	}
+}
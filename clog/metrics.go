@@ -0,0 +1,223 @@
+package clog
+
+import (
+	"expvar"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// WriterStats are the counters of one registered writer.
+type WriterStats struct {
+	Name      string // type and registration order, e.g. "*clog.FileWriter#0"
+	Records   uint64 // records written without error
+	Bytes     uint64 // their size as written, before compression or encryption
+	Dropped   uint64 // records the writer gave up, e.g. on full buffers
+	Rotations uint64
+	Deleted   uint64 // expired files removed
+}
+
+// Stats is a snapshot of the counters of a logger.
+type Stats struct {
+	Records        map[string]uint64 // records logged by level
+	TunnelDepth    int               // records waiting for the writer goroutine
+	TunnelCapacity int
+	Dropped        uint64            // records logged after Close and dropped
+	Errors         map[string]uint64 // writer failures by operation
+	Writers        []WriterStats
+}
+
+type writerStat struct {
+	name    string
+	records uint64
+	bytes   uint64
+}
+
+// levelFilter is implemented by writers skipping some levels, their
+// counters only include the records they write.
+type levelFilter interface {
+	accepts(level int) bool
+}
+
+// writeCounter is implemented by writers counting what they write
+// themselves, only they know which records they skip and what their
+// output is.
+type writeCounter interface {
+	Written() (records, bytes uint64)
+}
+
+// writeCount is the writeCounter of the writers of the package, embedded
+// first so that its atomics are aligned.
+type writeCount struct {
+	records uint64
+	bytes   uint64
+}
+
+// count accounts records written in size bytes.
+func (c *writeCount) count(records, size int) {
+	atomic.AddUint64(&c.records, uint64(records))
+	atomic.AddUint64(&c.bytes, uint64(size))
+}
+
+// Written returns how many records were written and their size.
+func (c *writeCount) Written() (records, bytes uint64) {
+	return atomic.LoadUint64(&c.records), atomic.LoadUint64(&c.bytes)
+}
+
+// countWrite accounts a record w wrote without error when w doesn't count
+// itself, l.mu held. Only its level filter tells a skipped record apart.
+func (l *Logger) countWrite(w Writer, enc *textEncoder) {
+	if _, ok := w.(writeCounter); ok {
+		return
+	}
+	if f, ok := w.(levelFilter); ok && !f.accepts(enc.level) {
+		return
+	}
+	if st := l.writerStats[w]; st != nil {
+		st.records++
+		st.bytes += uint64(len(enc.bytes))
+	}
+}
+
+// Stats returns the counters of l, shared with the loggers named from it.
+func (l *Logger) Stats() Stats {
+	st := Stats{
+		Records:        make(map[string]uint64, len(LEVEL_FLAGS)),
+		TunnelDepth:    len(l.tunnel),
+		TunnelCapacity: cap(l.tunnel),
+		Dropped:        atomic.LoadUint64(&l.dropped),
+		Errors:         make(map[string]uint64, int(numWriterOps)),
+	}
+	for lvl := range LEVEL_FLAGS {
+		st.Records[strings.ToLower(LEVEL_FLAGS[lvl])] = atomic.LoadUint64(&l.levelCounts[lvl])
+	}
+	for op := WriterOp(0); op < numWriterOps; op++ {
+		st.Errors[op.String()] = l.ErrorCount(op)
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, w := range l.writers {
+		ws := WriterStats{}
+		if s := l.writerStats[w]; s != nil {
+			ws.Name, ws.Records, ws.Bytes = s.name, s.records, s.bytes
+		}
+		if c, ok := w.(writeCounter); ok {
+			ws.Records, ws.Bytes = c.Written()
+		}
+		if d, ok := w.(interface{ Dropped() uint64 }); ok {
+			ws.Dropped = d.Dropped()
+		}
+		if r, ok := w.(interface{ Rotations() uint64 }); ok {
+			ws.Rotations = r.Rotations()
+		}
+		if d, ok := w.(interface{ Deleted() uint64 }); ok {
+			ws.Deleted = d.Deleted()
+		}
+		st.Writers = append(st.Writers, ws)
+	}
+	return st
+}
+
+// PublishExpvar publishes the Stats of l under name in expvar, which
+// panics if the name is taken.
+func (l *Logger) PublishExpvar(name string) {
+	expvar.Publish(name, expvar.Func(func() interface{} {
+		return l.Stats()
+	}))
+}
+
+// MetricsHandler serves the Stats of l in the Prometheus text format.
+func (l *Logger) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		rw.Write(appendPrometheus(nil, l.Stats()))
+	})
+}
+
+func appendPrometheus(b []byte, st Stats) []byte {
+	b = appendMetricHeader(b, "clog_records_total", "counter", "Records logged by level.")
+	for lvl := range LEVEL_FLAGS {
+		name := strings.ToLower(LEVEL_FLAGS[lvl])
+		b = appendMetric(b, "clog_records_total", "level", name, st.Records[name])
+	}
+	b = appendMetricHeader(b, "clog_tunnel_depth", "gauge", "Records waiting for the writer goroutine.")
+	b = appendMetric(b, "clog_tunnel_depth", "", "", uint64(st.TunnelDepth))
+	b = appendMetricHeader(b, "clog_tunnel_capacity", "gauge", "Capacity of the record queue.")
+	b = appendMetric(b, "clog_tunnel_capacity", "", "", uint64(st.TunnelCapacity))
+	b = appendMetricHeader(b, "clog_writer_errors_total", "counter", "Writer failures by operation.")
+	for op := WriterOp(0); op < numWriterOps; op++ {
+		b = appendMetric(b, "clog_writer_errors_total", "op", op.String(), st.Errors[op.String()])
+	}
+
+	b = appendMetricHeader(b, "clog_closed_dropped_records_total", "counter", "Records logged after Close and dropped.")
+	b = appendMetric(b, "clog_closed_dropped_records_total", "", "", st.Dropped)
+	for _, m := range []struct {
+		name, help string
+		value      func(w *WriterStats) uint64
+	}{
+		{"clog_dropped_records_total", "Records given up by writer.", func(w *WriterStats) uint64 { return w.Dropped }},
+		{"clog_writer_written_records_total", "Records written without error by writer.", func(w *WriterStats) uint64 { return w.Records }},
+		{"clog_writer_written_bytes_total", "Bytes of the records written by writer.", func(w *WriterStats) uint64 { return w.Bytes }},
+		{"clog_rotations_total", "File rotations by writer.", func(w *WriterStats) uint64 { return w.Rotations }},
+		{"clog_files_deleted_total", "Expired files removed by writer.", func(w *WriterStats) uint64 { return w.Deleted }},
+	} {
+		b = appendMetricHeader(b, m.name, "counter", m.help)
+		for i := range st.Writers {
+			b = appendMetric(b, m.name, "writer", st.Writers[i].Name, m.value(&st.Writers[i]))
+		}
+	}
+	return b
+}
+
+func appendMetricHeader(b []byte, name, typ, help string) []byte {
+	b = append(b, "# HELP "...)
+	b = append(b, name...)
+	b = append(b, ' ')
+	b = append(b, help...)
+	b = append(b, "\n# TYPE "...)
+	b = append(b, name...)
+	b = append(b, ' ')
+	b = append(b, typ...)
+	return append(b, '\n')
+}
+
+func appendMetric(b []byte, name, label, value string, v uint64) []byte {
+	b = append(b, name...)
+	if label != "" {
+		b = append(b, '{')
+		b = append(b, label...)
+		b = append(b, `="`...)
+		for i := 0; i < len(value); i++ {
+			switch c := value[i]; c {
+			case '\\', '"':
+				b = append(b, '\\', c)
+			case '\n':
+				b = append(b, '\\', 'n')
+			default:
+				b = append(b, c)
+			}
+		}
+		b = append(b, `"}`...)
+	}
+	b = append(b, ' ')
+	b = strconv.AppendUint(b, v, 10)
+	return append(b, '\n')
+}
+
+func writerName(w Writer, seq int) string {
+	return fmt.Sprintf("%T#%d", w, seq)
+}
+
+func GetStats() Stats {
+	return logger_default.Stats()
+}
+
+func PublishExpvar(name string) {
+	logger_default.PublishExpvar(name)
+}
+
+func MetricsHandler() http.Handler {
+	return logger_default.MetricsHandler()
+}
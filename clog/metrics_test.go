@@ -0,0 +1,85 @@
+package clog
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestStats(t *testing.T) {
+	l := NewLogger()
+	defer l.Close()
+	l.SetLevel(TRACE)
+	l.SetErrorHandler(func(*WriterError) {})
+	o := NewObserver(TRACE)
+	l.Register(o)
+	l.Register(failingWriter{})
+	l.Info("a")
+	l.Info("b")
+	l.Error("c")
+	l.Sync()
+
+	st := l.Stats()
+	if st.Records["info"] != 2 || st.Records["error"] != 1 || st.TunnelCapacity != tunnel_size_default {
+		t.Fatalf("unexpected stats %+v", st)
+	}
+	if st.Errors["write"] != 3 || len(st.Writers) != 2 || st.Writers[0].Records != 3 || st.Writers[0].Bytes == 0 {
+		t.Fatalf("unexpected writer stats %+v", st)
+	}
+
+	rec := httptest.NewRecorder()
+	l.MetricsHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body, _ := ioutil.ReadAll(rec.Body)
+	for _, want := range []string{
+		`clog_records_total{level="info"} 2`,
+		`clog_writer_errors_total{op="write"} 3`,
+		`clog_writer_written_records_total{writer="*clog.Observer#0"} 3`,
+		`clog_writer_written_records_total{writer="clog.failingWriter#1"} 0`,
+		"clog_closed_dropped_records_total 0",
+		"# TYPE clog_tunnel_depth gauge",
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Fatalf("%q missing in\n%s", want, body)
+		}
+	}
+}
+
+func TestStatsCountWrittenBytes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "clog-stats")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "error.log")
+
+	l := NewLogger()
+	defer l.Close()
+	l.SetLevel(TRACE)
+	w := NewFileWriter()
+	w.SetFileName(name)
+	w.SetLogLevelFloor(ERROR)
+	w.SetAudit([]byte("secret"))
+	l.Register(w)
+	o := NewObserver(WARNING)
+	l.Register(o)
+	l.Info("skipped")
+	l.Warn("observed")
+	l.Error("written")
+	l.Sync()
+
+	st := l.Stats()
+	info, err := os.Stat(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the audit chain makes the line longer than the record
+	if ws := st.Writers[0]; ws.Records != 1 || ws.Bytes != uint64(info.Size()) {
+		t.Fatalf("file writer stats %+v, file of %d bytes", ws, info.Size())
+	}
+	if ws := st.Writers[1]; ws.Records != 2 {
+		t.Fatalf("observer stats %+v", ws)
+	}
+}
@@ -0,0 +1,356 @@
+package clog
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+const net_memory_bytes_cnt = 1 << 20
+const net_spool_bytes_cnt = 256 << 20
+const net_queue_cnt = 1024
+
+// NetWriter forwards encoded records to a collector over TCP, UDP or a unix
+// socket, one record per datagram or framed by a newline or, with octet
+// counting, by its length as in RFC 6587. A goroutine of the writer dials
+// and sends, records finding its queue full are dropped.
+//
+// While the collector is unreachable records are kept in memory. Past the
+// memory limit they spill to the spool file, if one is set, and are replayed
+// in order before any newer record once the connection returns; without a
+// spool the oldest are dropped. A spool left by a previous run is replayed
+// too, records sent just before a crash may then be sent twice.
+type NetWriter struct {
+	writeCount
+
+	logLevelFloor int
+	logLevelCeil  int
+	sender        *sender
+
+	// owned by the sender goroutine once started
+	network      string
+	addr         string
+	octets       bool
+	dialTimeout  time.Duration
+	writeTimeout time.Duration
+	conn         net.Conn
+	retry        backoff
+
+	mem      [][]byte
+	memBytes int
+	maxMem   int
+
+	spoolPath string
+	spool     *os.File
+	spoolOff  int64 // next frame to replay
+	spoolEnd  int64
+	maxSpool  int64
+
+	dropped uint64
+}
+
+func NewNetWriter(network, addr string) *NetWriter {
+	return &NetWriter{
+		logLevelCeil: PUBLIC,
+		network:      network,
+		addr:         addr,
+		dialTimeout:  time.Second,
+		writeTimeout: 5 * time.Second,
+		retry:        newBackoff(100*time.Millisecond, 30*time.Second),
+		maxMem:       net_memory_bytes_cnt,
+		maxSpool:     net_spool_bytes_cnt,
+	}
+}
+
+func (w *NetWriter) SetLogLevelFloor(floor int) {
+	w.logLevelFloor = floor
+}
+
+func (w *NetWriter) SetLogLevelCeil(ceil int) {
+	w.logLevelCeil = ceil
+}
+
+// SetOctetCounting prefixes each record with its length instead of ending
+// it with a newline, for records that may contain newlines.
+func (w *NetWriter) SetOctetCounting(on bool) {
+	w.octets = on
+}
+
+func (w *NetWriter) SetWriteTimeout(d time.Duration) {
+	w.writeTimeout = d
+}
+
+// SetMemoryLimit bounds the bytes kept in memory while disconnected.
+func (w *NetWriter) SetMemoryLimit(n int) {
+	w.maxMem = n
+}
+
+// SetSpool sets the file records spill to past the memory limit, holding at
+// most max bytes; 0 keeps the default of 256MiB.
+func (w *NetWriter) SetSpool(path string, max int64) {
+	w.spoolPath = path
+	if max > 0 {
+		w.maxSpool = max
+	}
+}
+
+// Dropped returns how many records were lost to full buffers.
+func (w *NetWriter) Dropped() uint64 {
+	return atomic.LoadUint64(&w.dropped)
+}
+
+func (w *NetWriter) Init() error {
+	switch w.network {
+	case "tcp", "tcp4", "tcp6", "udp", "udp4", "udp6", "unix", "unixgram":
+	default:
+		return errors.New("Invalid network (" + w.network + ")")
+	}
+	if w.addr == "" {
+		return errors.New("network address missing")
+	}
+	if w.spoolPath != "" {
+		if err := w.openSpool(); err != nil {
+			return err
+		}
+	}
+	w.sender = startSender(w, net_queue_cnt, &w.dropped)
+	return nil
+}
+
+func (w *NetWriter) openSpool() error {
+	f, err := os.OpenFile(w.spoolPath, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	w.spool = f
+	w.spoolOff = 0
+	// keep only whole frames, the last may have been cut by a crash
+	var hdr [4]byte
+	for {
+		if _, err := f.ReadAt(hdr[:], w.spoolEnd); err != nil {
+			break
+		}
+		next := w.spoolEnd + 4 + int64(binary.BigEndian.Uint32(hdr[:]))
+		if fi, err := f.Stat(); err != nil || next > fi.Size() {
+			break
+		}
+		w.spoolEnd = next
+	}
+	return f.Truncate(w.spoolEnd)
+}
+
+func (w *NetWriter) connect() bool {
+	if w.conn != nil {
+		return true
+	}
+	now := time.Now()
+	if !w.retry.ready(now) {
+		return false
+	}
+	conn, err := net.DialTimeout(w.network, w.addr, w.dialTimeout)
+	if err != nil {
+		w.retry.fail(now)
+		return false
+	}
+	w.retry.reset()
+	w.conn = conn
+	return true
+}
+
+func (w *NetWriter) disconnect() {
+	w.conn.Close()
+	w.conn = nil
+	w.retry.fail(time.Now())
+}
+
+func (w *NetWriter) accepts(level int) bool {
+	return levelBetween(level, w.logLevelFloor, w.logLevelCeil)
+}
+
+func (w *NetWriter) Write(enc *textEncoder) error {
+	if !w.accepts(enc.level) {
+		return nil
+	}
+	msg := enc.bytes
+	if n := len(msg); n > 0 && msg[n-1] == '\n' {
+		msg = msg[:n-1]
+	}
+	w.sender.put(append([]byte(nil), msg...), 1)
+	if enc.level == PANIC || enc.level == FATAL {
+		w.sender.sync()
+	}
+	return nil
+}
+
+// Flush returns an error the last sends met.
+func (w *NetWriter) Flush() error {
+	if w.sender == nil {
+		return nil
+	}
+	return w.sender.err()
+}
+
+func (w *NetWriter) deliver(msg []byte, n int) error {
+	if w.spoolEnd == 0 && len(w.mem) == 0 && w.connect() {
+		err := w.send(msg)
+		if err == nil {
+			return nil
+		}
+		w.disconnect()
+		w.queue(msg)
+		return err
+	}
+	w.queue(msg)
+	return w.resend()
+}
+
+// queue keeps msg behind the records already waiting.
+func (w *NetWriter) queue(msg []byte) {
+	if w.spoolEnd > 0 {
+		w.appendSpool(msg)
+		return
+	}
+	w.mem = append(w.mem, msg)
+	w.memBytes += len(msg)
+	if w.memBytes <= w.maxMem {
+		return
+	}
+	if w.spool != nil {
+		for _, m := range w.mem {
+			w.appendSpool(m)
+		}
+		w.mem, w.memBytes = nil, 0
+		return
+	}
+	for w.memBytes > w.maxMem && len(w.mem) > 1 {
+		w.memBytes -= len(w.mem[0])
+		w.mem[0] = nil
+		w.mem = w.mem[1:]
+		atomic.AddUint64(&w.dropped, 1)
+	}
+}
+
+func (w *NetWriter) appendSpool(msg []byte) {
+	if w.spoolEnd+4+int64(len(msg)) > w.maxSpool {
+		atomic.AddUint64(&w.dropped, 1)
+		return
+	}
+	frame := make([]byte, 4+len(msg))
+	binary.BigEndian.PutUint32(frame, uint32(len(msg)))
+	copy(frame[4:], msg)
+	if _, err := w.spool.WriteAt(frame, w.spoolEnd); err != nil {
+		atomic.AddUint64(&w.dropped, 1)
+		return
+	}
+	w.spoolEnd += int64(len(frame))
+}
+
+func (w *NetWriter) send(msg []byte) error {
+	var frame []byte
+	switch {
+	case w.network == "udp" || w.network == "udp4" || w.network == "udp6" || w.network == "unixgram":
+		frame = msg
+	case w.octets:
+		frame = strconv.AppendInt(make([]byte, 0, len(msg)+8), int64(len(msg)), 10)
+		frame = append(frame, ' ')
+		frame = append(frame, msg...)
+	default:
+		frame = append(msg[:len(msg):len(msg)], '\n')
+	}
+	if w.writeTimeout > 0 {
+		w.conn.SetWriteDeadline(time.Now().Add(w.writeTimeout))
+	}
+	if _, err := w.conn.Write(frame); err != nil {
+		return err
+	}
+	w.count(1, len(frame))
+	return nil
+}
+
+// resend replays the spool then the records held in memory once the
+// collector is reachable.
+func (w *NetWriter) resend() error {
+	if (w.spoolEnd == 0 && len(w.mem) == 0) || !w.connect() {
+		return nil
+	}
+	var hdr [4]byte
+	for w.spoolOff < w.spoolEnd {
+		if _, err := w.spool.ReadAt(hdr[:], w.spoolOff); err != nil {
+			return w.resetSpool(err)
+		}
+		msg := make([]byte, binary.BigEndian.Uint32(hdr[:]))
+		if _, err := w.spool.ReadAt(msg, w.spoolOff+4); err != nil {
+			return w.resetSpool(err)
+		}
+		if err := w.send(msg); err != nil {
+			w.disconnect()
+			return err
+		}
+		w.spoolOff += 4 + int64(len(msg))
+	}
+	if w.spoolEnd > 0 {
+		if err := w.resetSpool(nil); err != nil {
+			return err
+		}
+	}
+	for len(w.mem) > 0 {
+		if err := w.send(w.mem[0]); err != nil {
+			w.disconnect()
+			return err
+		}
+		w.memBytes -= len(w.mem[0])
+		w.mem[0] = nil
+		w.mem = w.mem[1:]
+	}
+	return nil
+}
+
+// resetSpool empties the spool once replayed, or when it can't be read.
+func (w *NetWriter) resetSpool(err error) error {
+	if err == io.EOF {
+		err = nil
+	}
+	w.spoolOff, w.spoolEnd = 0, 0
+	if terr := w.spool.Truncate(0); err == nil {
+		err = terr
+	}
+	return err
+}
+
+// Close tries a last time to send what is pending; records still waiting
+// stay in the spool for the next run.
+func (w *NetWriter) Close() error {
+	if w.sender == nil {
+		return nil
+	}
+	err := w.sender.close()
+	w.sender = nil
+	return err
+}
+
+func (w *NetWriter) shutdown() error {
+	err := w.resend()
+	if w.spool == nil && len(w.mem) > 0 {
+		atomic.AddUint64(&w.dropped, uint64(len(w.mem)))
+		w.mem, w.memBytes = nil, 0
+	} else if len(w.mem) > 0 {
+		for _, m := range w.mem {
+			w.appendSpool(m)
+		}
+		w.mem, w.memBytes = nil, 0
+	}
+	if w.spool != nil {
+		w.spool.Close()
+		w.spool = nil
+	}
+	if w.conn != nil {
+		w.conn.Close()
+		w.conn = nil
+	}
+	return err
+}
@@ -0,0 +1,83 @@
+package clog
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestNetWriterSpoolReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "clog-net")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// reserve a port with nobody listening yet
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	w := NewNetWriter("tcp", addr)
+	w.SetMemoryLimit(16)
+	spool := filepath.Join(dir, "spool")
+	w.SetSpool(spool, 0)
+	w.retry = newBackoff(time.Millisecond, time.Millisecond)
+	if err := w.Init(); err != nil {
+		t.Fatal(err)
+	}
+	enc := &textEncoder{level: INFO}
+	for i := 0; i < 10; i++ {
+		enc.bytes = append(enc.bytes[:0], "record "+strconv.Itoa(i)+"\n"...)
+		if err := w.Write(enc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w.sender.sync()
+	if fi, err := os.Stat(spool); err != nil || fi.Size() == 0 {
+		t.Fatal("records were not spooled")
+	}
+
+	ln, err = net.Listen("tcp", addr)
+	if err != nil {
+		t.Skip("port taken again:", err)
+	}
+	defer ln.Close()
+	enc.bytes = append(enc.bytes[:0], "record 10\n"...)
+	if err := w.Write(enc); err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	s := bufio.NewScanner(conn)
+	for i := 0; i <= 10; i++ {
+		if !s.Scan() {
+			t.Fatal(s.Err())
+		}
+		if want := "record " + strconv.Itoa(i); s.Text() != want {
+			t.Fatalf("got %q, want %q", s.Text(), want)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if fi, err := os.Stat(spool); err != nil || fi.Size() != 0 {
+		t.Fatal("spool not drained")
+	}
+	if w.Dropped() != 0 {
+		t.Fatalf("dropped %d", w.Dropped())
+	}
+}
@@ -0,0 +1,111 @@
+package clog
+
+import (
+	"strings"
+	"sync"
+)
+
+// Observer is a writer keeping every record it is given, for tests to
+// look at what was logged. Use NewObservedLogger to get one along with a
+// synchronous logger, so records are there as soon as the log call returns.
+type Observer struct {
+	writeCount
+
+	mu      sync.Mutex
+	floor   int
+	records []Record
+}
+
+// NewObserver keeps the records at or above level.
+func NewObserver(level int) *Observer {
+	return &Observer{floor: level}
+}
+
+// NewObservedLogger returns a synchronous logger at level writing only to
+// the returned observer.
+func NewObservedLogger(level int) (*Logger, *Observer) {
+	o := NewObserver(level)
+	l := NewLogger()
+	l.SetLevel(level)
+	l.SetSynchronous(true)
+	l.Register(o)
+	return l, o
+}
+
+func (o *Observer) Init() error {
+	return nil
+}
+
+func (o *Observer) readsFields() {}
+
+func (o *Observer) Write(enc *textEncoder) error {
+	if enc.rec == nil || !atLeast(enc.rec.level, o.floor) {
+		return nil
+	}
+	o.mu.Lock()
+	o.records = append(o.records, *enc.rec)
+	o.mu.Unlock()
+	o.count(1, len(enc.bytes))
+	return nil
+}
+
+func (o *Observer) Len() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return len(o.records)
+}
+
+// All returns a copy of the kept records, oldest first.
+func (o *Observer) All() []Record {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return append([]Record(nil), o.records...)
+}
+
+// TakeAll returns the kept records and forgets them.
+func (o *Observer) TakeAll() []Record {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	records := o.records
+	o.records = nil
+	return records
+}
+
+// Filter returns the kept records for which match is true.
+func (o *Observer) Filter(match func(r *Record) bool) []Record {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	var out []Record
+	for i := range o.records {
+		if match(&o.records[i]) {
+			out = append(out, o.records[i])
+		}
+	}
+	return out
+}
+
+func (o *Observer) FilterLevel(level int) []Record {
+	return o.Filter(func(r *Record) bool { return r.level == level })
+}
+
+// FilterMessage returns the records whose message contains substr.
+func (o *Observer) FilterMessage(substr string) []Record {
+	return o.Filter(func(r *Record) bool { return strings.Contains(r.message(), substr) })
+}
+
+// FilterField returns the records holding a field with the key and the
+// value of f, values compared as written in text records.
+func (o *Observer) FilterField(f Field) []Record {
+	return o.Filter(func(r *Record) bool { return r.HasField(f) })
+}
+
+// HasField tells if r holds a field with the key and the value of f.
+func (r *Record) HasField(f Field) bool {
+	want := f.Value()
+	for i := range r.fields {
+		if r.fields[i].key == f.key && r.fields[i].Value() == want {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,293 @@
+package clog
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// redactMask replaces the value of sensitive keys and Secret fields.
+const redactMask = "******"
+
+// builtinRedactRules are the patterns enabled by name with AddBuiltin.
+var builtinRedactRules = map[string]struct {
+	expr, repl string
+	valid      func(string) bool
+}{
+	"card":   {`\b\d(?:[ -]?\d){12,18}\b`, "[REDACTED CARD]", luhnValid},
+	"email":  {`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`, "[REDACTED EMAIL]", nil},
+	"bearer": {`(?i)\bbearer\s+[A-Za-z0-9\-._~+/]+=*`, "Bearer [REDACTED]", nil},
+}
+
+// luhnValid tells whether the digits of s pass the Luhn check of card
+// numbers, so that ids and timestamps of the same length are kept.
+func luhnValid(s string) bool {
+	sum, n := 0, 0
+	for i := len(s) - 1; i >= 0; i-- {
+		c := s[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+		d := int(c - '0')
+		if n%2 == 1 {
+			if d *= 2; d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		n++
+	}
+	return n > 0 && sum%10 == 0
+}
+
+type redactRule struct {
+	re    *regexp.Regexp
+	repl  string
+	valid func(string) bool // matches failing it are kept, repl is literal
+}
+
+// Redactor hides sensitive data before a record is encoded: values of
+// sensitive keys are masked or hashed, and pattern rules are replaced in
+// messages and string values, down to the keys and strings nested in
+// objects, arrays, reflected values and errors. Configure it before
+// SetRedactor.
+type Redactor struct {
+	keys    map[string]bool
+	hashKey []byte
+	rules   []redactRule
+}
+
+func NewRedactor() *Redactor {
+	return &Redactor{keys: make(map[string]bool)}
+}
+
+// AddKeys marks keys as sensitive, compared case-insensitively.
+func (rd *Redactor) AddKeys(keys ...string) {
+	for _, key := range keys {
+		rd.keys[strings.ToLower(key)] = true
+	}
+}
+
+// SetHashKey writes "hmac:" and the start of the HMAC-SHA256 of the value
+// under key instead of a mask for sensitive keys, so equal values can
+// still be correlated by those who hold the key. An empty key masks.
+func (rd *Redactor) SetHashKey(key []byte) {
+	rd.hashKey = append([]byte(nil), key...)
+}
+
+// AddPattern replaces every match of expr by replacement, which may refer
+// to groups as in regexp.Expand.
+func (rd *Redactor) AddPattern(expr, replacement string) error {
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return err
+	}
+	rd.rules = append(rd.rules, redactRule{re: re, repl: replacement})
+	return nil
+}
+
+// AddBuiltin enables a predefined pattern: "card", "email" or "bearer".
+func (rd *Redactor) AddBuiltin(name string) error {
+	rule, ok := builtinRedactRules[name]
+	if !ok {
+		return errors.New("Invalid redact rule (" + name + ")")
+	}
+	if err := rd.AddPattern(rule.expr, rule.repl); err != nil {
+		return err
+	}
+	rd.rules[len(rd.rules)-1].valid = rule.valid
+	return nil
+}
+
+func (rd *Redactor) sensitive(key string) bool {
+	return rd.keys[strings.ToLower(key)]
+}
+
+func (rd *Redactor) conceal(val string) string {
+	if len(rd.hashKey) == 0 {
+		return redactMask
+	}
+	mac := hmac.New(sha256.New, rd.hashKey)
+	mac.Write([]byte(val))
+	return "hmac:" + hex.EncodeToString(mac.Sum(nil)[:8])
+}
+
+func (rd *Redactor) scrub(s string) string {
+	for _, rule := range rd.rules {
+		if rule.valid == nil {
+			s = rule.re.ReplaceAllString(s, rule.repl)
+			continue
+		}
+		s = rule.re.ReplaceAllStringFunc(s, func(m string) string {
+			if rule.valid(m) {
+				return rule.repl
+			}
+			return m
+		})
+	}
+	return s
+}
+
+// value renders val for key as the keys/values functions print it.
+func (rd *Redactor) value(key string, val interface{}) string {
+	s := fmt.Sprintf("%+v", val)
+	if rd.sensitive(key) {
+		return rd.conceal(s)
+	}
+	return rd.scrub(s)
+}
+
+// redact rewrites the message and fields of r, leaving the slice given by
+// the caller untouched.
+func (rd *Redactor) redact(r *Record) {
+	r.info = rd.scrub(r.message())
+	r.args = nil
+	r.fields = rd.fields(r.fields)
+}
+
+func (rd *Redactor) fields(fields []Field) []Field {
+	if len(fields) == 0 {
+		return fields
+	}
+	out := make([]Field, len(fields))
+	for i, f := range fields {
+		out[i] = rd.field(f)
+	}
+	return out
+}
+
+// field redacts f, walking the values snapshotFields captured.
+func (rd *Redactor) field(f Field) Field {
+	switch {
+	case f.fieldType == secretType || f.fieldType == namespaceType:
+		return f
+	case rd.sensitive(f.key):
+		return String(f.key, rd.conceal(string(f.WriteValue(nil))))
+	}
+	switch f.fieldType {
+	case stringType, stackType, rawType:
+		f.str = rd.scrub(f.str)
+	case stringerType:
+		f = String(f.key, rd.scrub(f.obj.(fmt.Stringer).String()))
+	case errorType:
+		f.obj = rd.errorInfo(f.obj.(*errorInfo))
+	case objectType:
+		r, ok := f.obj.(*reflected)
+		if !ok {
+			r = newReflected(f.obj)
+		}
+		f.obj = rd.reflected(r)
+	case objectMarshalerType:
+		c, ok := f.obj.(*capturedObject)
+		if !ok {
+			c = captureObject(f.obj.(ObjectMarshaler))
+		}
+		f.obj = &capturedObject{fields: rd.fields(c.fields), err: c.err}
+	case arrayType:
+		c, ok := f.obj.(*capturedArray)
+		if !ok {
+			c = captureArray(f.obj.(ArrayMarshaler))
+		}
+		f.obj = &capturedArray{values: rd.fields(c.values), err: c.err}
+	}
+	return f
+}
+
+func (rd *Redactor) errorInfo(info *errorInfo) *errorInfo {
+	out := &errorInfo{
+		msg:     rd.scrub(info.msg),
+		typ:     info.typ,
+		verbose: rd.scrub(info.verbose),
+	}
+	for _, cause := range info.causes {
+		out.causes = append(out.causes, rd.errorInfo(cause))
+	}
+	return out
+}
+
+// reflected redacts the JSON form of r by key and string. When a key was
+// hidden the text form, which %+v printed from the same value, is replaced
+// by the redacted JSON.
+func (rd *Redactor) reflected(r *reflected) *reflected {
+	out := &reflected{text: rd.scrub(r.text), raw: r.raw, err: r.err}
+	if r.err != nil {
+		return out
+	}
+	dec := json.NewDecoder(bytes.NewReader(r.raw))
+	dec.UseNumber()
+	var val interface{}
+	if err := dec.Decode(&val); err != nil {
+		return out
+	}
+	val, changed, hidden := rd.jsonValue(val)
+	if !changed {
+		return out
+	}
+	raw, err := json.Marshal(val)
+	if err != nil {
+		return out
+	}
+	out.raw = raw
+	if hidden {
+		out.text = string(raw)
+	}
+	return out
+}
+
+// jsonValue redacts a decoded JSON value, changed tells whether a string
+// was scrubbed or a key hidden, hidden whether a key was.
+func (rd *Redactor) jsonValue(val interface{}) (out interface{}, changed, hidden bool) {
+	switch v := val.(type) {
+	case string:
+		s := rd.scrub(v)
+		return s, s != v, false
+	case map[string]interface{}:
+		for key, elem := range v {
+			if rd.sensitive(key) {
+				v[key] = rd.conceal(jsonText(elem))
+				changed, hidden = true, true
+				continue
+			}
+			elem, c, h := rd.jsonValue(elem)
+			v[key] = elem
+			changed, hidden = changed || c, hidden || h
+		}
+	case []interface{}:
+		for i, elem := range v {
+			elem, c, h := rd.jsonValue(elem)
+			v[i] = elem
+			changed, hidden = changed || c, hidden || h
+		}
+	}
+	return val, changed, hidden
+}
+
+// jsonText is the text of a decoded JSON value as conceal hashes it.
+func jsonText(val interface{}) string {
+	if s, ok := val.(string); ok {
+		return s
+	}
+	b, _ := json.Marshal(val)
+	return string(b)
+}
+
+// Secret logs key with a mask, the value is never written.
+func Secret(key string, val string) Field {
+	return Field{key: key, fieldType: secretType}
+}
+
+// SetRedactor applies rd to every record of l and the loggers named from
+// it, nil turns redaction off.
+func (l *Logger) SetRedactor(rd *Redactor) {
+	l.redactor = rd
+}
+
+func SetRedactor(rd *Redactor) {
+	logger_default.SetRedactor(rd)
+}
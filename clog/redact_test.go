@@ -0,0 +1,134 @@
+package clog
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+type account struct {
+	User     string
+	Password string
+	Note     string
+}
+
+type credentials struct {
+	user, password string
+}
+
+func (c credentials) MarshalLogObject(enc ObjectEncoder) error {
+	enc.AddString("user", c.user)
+	enc.AddString("password", c.password)
+	return nil
+}
+
+func (c credentials) String() string {
+	return c.user + ":" + c.password
+}
+
+func redactedRecord(t *testing.T, rd *Redactor, msg string, fields ...Field) (text, js string) {
+	l := NewLogger()
+	l.SetRedactor(rd)
+	c := make(recordChan, 1)
+	l.Register(c)
+	l.deliverRecordToWriterHight(INFO, msg, fields...)
+	r := <-c
+	return render(r.info, r.fields...)
+}
+
+func TestRedactNestedFields(t *testing.T) {
+	rd := NewRedactor()
+	rd.AddKeys("password")
+	if err := rd.AddBuiltin("email"); err != nil {
+		t.Fatal(err)
+	}
+	mail := "bob@example.com"
+	text, js := redactedRecord(t, rd, "from "+mail,
+		Stringer("s", credentials{"bob", mail}),
+		Err(fmt.Errorf("send to %s: %w", mail, errors.New("refused by "+mail))),
+		Object("c", credentials{"bob", "hunter2"}),
+		Object("a", account{User: "bob", Password: "hunter2", Note: mail}),
+		Dict("d", String("password", "hunter2"), Dict("inner", String("password", "hunter2"))),
+		Array("l", objects{credentials{"bob", "hunter2"}}),
+		Strings("mails", []string{mail}),
+	)
+	for _, out := range []string{text, js} {
+		if strings.Contains(out, "hunter2") || strings.Contains(out, mail) {
+			t.Errorf("secret left in %q", out)
+		}
+	}
+	if want := `"a":{"Note":"[REDACTED EMAIL]","Password":"******","User":"bob"}`; !strings.Contains(js, want) {
+		t.Errorf("json %q, want %q", js, want)
+	}
+	if want := ` a={"Note":"[REDACTED\ EMAIL]","Password":"******","User":"bob"} `; !strings.Contains(text, want) {
+		t.Errorf("text %q, want %q", text, want)
+	}
+	if want := ` d={password=******\ inner={password=******}} `; !strings.Contains(text, want) {
+		t.Errorf("text %q, want %q", text, want)
+	}
+}
+
+type objects []ObjectMarshaler
+
+func (objs objects) MarshalLogArray(enc ArrayEncoder) error {
+	for _, obj := range objs {
+		if err := enc.AppendObject(obj); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestRedactHashKey(t *testing.T) {
+	key := []byte("secret")
+	rd := NewRedactor()
+	rd.AddKeys("token")
+	rd.SetHashKey(key)
+	text, _ := redactedRecord(t, rd, "m", String("token", "abc"))
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("abc"))
+	if want := " token=hmac:" + hex.EncodeToString(mac.Sum(nil)[:8]) + "\n"; !strings.HasSuffix(text, want) {
+		t.Errorf("text %q, want suffix %q", text, want)
+	}
+}
+
+func TestRedactCardLuhn(t *testing.T) {
+	rd := NewRedactor()
+	if err := rd.AddBuiltin("card"); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct{ in, out string }{
+		{"card 4111 1111 1111 1111", "card [REDACTED CARD]"},
+		{"card 4111-1111-1111-1111", "card [REDACTED CARD]"},
+		{"order 4111111111111112", "order 4111111111111112"},
+		{"at 1700000000000", "at 1700000000000"},
+	} {
+		if got := rd.scrub(c.in); got != c.out {
+			t.Errorf("scrub(%q) = %q, want %q", c.in, got, c.out)
+		}
+	}
+}
+
+func TestRedactFatalStackDump(t *testing.T) {
+	l := NewLogger()
+	rd := NewRedactor()
+	if err := rd.AddPattern(`goroutine \d+`, "goroutine N"); err != nil {
+		t.Fatal(err)
+	}
+	l.SetRedactor(rd)
+	l.SetFatalStackDump(true)
+	l.SetExitFunc(func(int) {})
+	c := make(recordChan, 2)
+	l.Register(c)
+
+	l.Fatal("down")
+	<-c
+	if dump := <-c; !strings.Contains(dump.info, "goroutine N [") {
+		t.Errorf("stack dump not redacted: %q", dump.info)
+	}
+}
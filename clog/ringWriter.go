@@ -0,0 +1,275 @@
+package clog
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const ring_records_cnt_default = 4096
+const ring_follow_backlog = 256
+
+// RingQuery selects records of a RingWriter, zero values match everything.
+type RingQuery struct {
+	Level    int // lowest level
+	Since    time.Time
+	Until    time.Time
+	Contains string // substring of the encoded line
+	Limit    int    // most recent records kept
+}
+
+func (q *RingQuery) match(e *ringEntry) bool {
+	switch {
+	case !atLeast(e.rec.level, q.Level):
+		return false
+	case !q.Since.IsZero() && e.rec.time.Before(q.Since):
+		return false
+	case !q.Until.IsZero() && e.rec.time.After(q.Until):
+		return false
+	case q.Contains != "" && !bytes.Contains(e.line, []byte(q.Contains)):
+		return false
+	}
+	return true
+}
+
+type ringEntry struct {
+	rec    Record
+	line   []byte
+	limits Limits
+}
+
+// RingWriter keeps the most recent records in memory, to look at a live
+// process without touching disk. Handler serves them over HTTP.
+type RingWriter struct {
+	writeCount
+
+	mu      sync.Mutex
+	entries []ringEntry
+	next    int
+	full    bool
+	follow  map[chan *ringEntry]struct{}
+	closed  bool
+}
+
+// NewRingWriter keeps the last size records, 4096 if size is 0.
+func NewRingWriter(size int) *RingWriter {
+	if size <= 0 {
+		size = ring_records_cnt_default
+	}
+	return &RingWriter{
+		entries: make([]ringEntry, size),
+		follow:  make(map[chan *ringEntry]struct{}),
+	}
+}
+
+func (w *RingWriter) Init() error {
+	return nil
+}
+
+func (w *RingWriter) readsFields() {}
+
+func (w *RingWriter) Write(enc *textEncoder) error {
+	if enc.rec == nil {
+		return nil
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	e := &w.entries[w.next]
+	e.rec = *enc.rec
+	e.line = append(e.line[:0], enc.bytes...)
+	e.limits = enc.limits
+	w.count(1, len(e.line))
+	if w.next++; w.next == len(w.entries) {
+		w.next, w.full = 0, true
+	}
+	if len(w.follow) > 0 {
+		// followers get their own copy, the slot is reused
+		c := &ringEntry{rec: e.rec, line: append([]byte(nil), e.line...), limits: e.limits}
+		for ch := range w.follow {
+			select {
+			case ch <- c:
+			default: // too slow, skip
+			}
+		}
+	}
+	return nil
+}
+
+// Close ends the live tails, the records can still be queried.
+func (w *RingWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for ch := range w.follow {
+		close(ch)
+		delete(w.follow, ch)
+	}
+	w.closed = true
+	return nil
+}
+
+func (w *RingWriter) query(q RingQuery) []ringEntry {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.queryLocked(q)
+}
+
+func (w *RingWriter) queryLocked(q RingQuery) []ringEntry {
+	var out []ringEntry
+	n := w.next
+	if w.full {
+		n = len(w.entries)
+	}
+	// newest first up to the limit, then back in time order
+	for i := 0; i < n; i++ {
+		e := &w.entries[(w.next-1-i+len(w.entries))%len(w.entries)]
+		if !q.match(e) {
+			continue
+		}
+		out = append(out, ringEntry{rec: e.rec, line: append([]byte(nil), e.line...), limits: e.limits})
+		if q.Limit > 0 && len(out) == q.Limit {
+			break
+		}
+	}
+	for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+		out[i], out[j] = out[j], out[i]
+	}
+	return out
+}
+
+// Records returns the kept records matching q, oldest first.
+func (w *RingWriter) Records(q RingQuery) []Record {
+	entries := w.query(q)
+	records := make([]Record, len(entries))
+	for i := range entries {
+		records[i] = entries[i].rec
+	}
+	return records
+}
+
+// Handler serves the kept records, filtered by the query parameters
+// level (e.g. "warn"), since and until (RFC 3339 or a duration back from
+// now such as "5m"), q (substring) and limit. format=json writes JSON
+// lines instead of the text lines, follow=1 goes on with new records
+// until the client leaves.
+func (w *RingWriter) Handler() http.Handler {
+	return http.HandlerFunc(w.serveHTTP)
+}
+
+func (w *RingWriter) serveHTTP(rw http.ResponseWriter, req *http.Request) {
+	q, err := parseRingQuery(req)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	asJSON := req.FormValue("format") == "json"
+	follow := req.FormValue("follow") == "1" || req.FormValue("follow") == "true"
+	if asJSON {
+		rw.Header().Set("Content-Type", "application/x-ndjson")
+	} else {
+		rw.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	}
+
+	var ch chan *ringEntry
+	w.mu.Lock()
+	entries := w.queryLocked(q)
+	if follow && !w.closed {
+		// subscribed along with the dump, no record is missed or repeated
+		ch = make(chan *ringEntry, ring_follow_backlog)
+		w.follow[ch] = struct{}{}
+		defer func() {
+			w.mu.Lock()
+			delete(w.follow, ch)
+			w.mu.Unlock()
+		}()
+	} else {
+		follow = false
+	}
+	w.mu.Unlock()
+
+	var buf []byte
+	for i := range entries {
+		buf = appendRingEntry(buf, &entries[i], asJSON)
+	}
+	if _, err := rw.Write(buf); err != nil || !follow {
+		return
+	}
+	flusher, _ := rw.(http.Flusher)
+	if flusher != nil {
+		flusher.Flush()
+	}
+	q.Limit = 0
+	q.Until = time.Time{}
+	for {
+		select {
+		case e, ok := <-ch:
+			if !ok {
+				return
+			}
+			if !q.match(e) {
+				continue
+			}
+			if _, err := rw.Write(appendRingEntry(buf[:0], e, asJSON)); err != nil {
+				return
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		case <-req.Context().Done():
+			return
+		}
+	}
+}
+
+func appendRingEntry(b []byte, e *ringEntry, asJSON bool) []byte {
+	if !asJSON {
+		return append(b, e.line...)
+	}
+	enc := &textEncoder{bytes: b, limits: e.limits}
+	e.rec.JSON(enc)
+	return enc.bytes
+}
+
+func parseRingQuery(req *http.Request) (q RingQuery, err error) {
+	if s := req.FormValue("level"); s != "" {
+		lvl, ok := ParseLevel(s)
+		if !ok {
+			return q, errBadParam("level", s)
+		}
+		q.Level = lvl
+	}
+	now := time.Now()
+	if q.Since, err = parseRingTime("since", req.FormValue("since"), now); err != nil {
+		return
+	}
+	if q.Until, err = parseRingTime("until", req.FormValue("until"), now); err != nil {
+		return
+	}
+	q.Contains = req.FormValue("q")
+	if s := req.FormValue("limit"); s != "" {
+		if q.Limit, err = strconv.Atoi(s); err != nil || q.Limit < 0 {
+			return q, errBadParam("limit", s)
+		}
+	}
+	return q, nil
+}
+
+func parseRingTime(name, s string, now time.Time) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return now.Add(-d), nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return t, errBadParam(name, s)
+	}
+	return t, nil
+}
+
+func errBadParam(name, value string) error {
+	return errors.New("Invalid " + name + " (" + value + ")")
+}
@@ -0,0 +1,62 @@
+package clog
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeRing(w *RingWriter, level int, msg string) {
+	r := &Record{time: time.Now(), info: msg, level: level}
+	w.Write(&textEncoder{bytes: []byte(msg + "\n"), level: level, rec: r})
+}
+
+func TestRingWriter(t *testing.T) {
+	w := NewRingWriter(3)
+	for i := 0; i < 5; i++ {
+		level := INFO
+		if i%2 == 1 {
+			level = ERROR
+		}
+		writeRing(w, level, "record "+strconv.Itoa(i))
+	}
+	records := w.Records(RingQuery{})
+	if len(records) != 3 || records[0].Message() != "record 2" || records[2].Message() != "record 4" {
+		t.Fatalf("unexpected records %v", records)
+	}
+	if records = w.Records(RingQuery{Level: ERROR}); len(records) != 1 || records[0].Message() != "record 3" {
+		t.Fatalf("level filter: %v", records)
+	}
+
+	srv := httptest.NewServer(w.Handler())
+	defer srv.Close()
+	resp, err := http.Get(srv.URL + "?q=record+4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "record 4\n" {
+		t.Fatalf("got %q", body)
+	}
+
+	resp, err = http.Get(srv.URL + "?follow=1&level=error&limit=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	s := bufio.NewScanner(resp.Body)
+	if !s.Scan() || s.Text() != "record 3" {
+		t.Fatalf("got %q", s.Text())
+	}
+	writeRing(w, INFO, "skipped")
+	writeRing(w, ERROR, "record 5")
+	if !s.Scan() || !strings.HasPrefix(s.Text(), "record 5") {
+		t.Fatalf("tail got %q", s.Text())
+	}
+}
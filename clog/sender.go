@@ -0,0 +1,119 @@
+package clog
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// sender_retry_interval is how often a sender lets its handler resend what
+// a failure left waiting.
+const sender_retry_interval = 100 * time.Millisecond
+
+// senderHandler is the network side of a writer driven by a sender. Its
+// methods are only called from the sender goroutine.
+type senderHandler interface {
+	// deliver takes a message queued by the writer, n records long.
+	deliver(msg []byte, n int) error
+	// resend sends what an earlier failure left waiting.
+	resend() error
+	// shutdown makes a last attempt and releases the connection.
+	shutdown() error
+}
+
+// sender keeps dial, write and request timeouts of network writers off
+// the writer goroutine of the logger. put queues a message without
+// blocking, dropping it when the queue is full, and a goroutine of its own
+// hands the messages in order to the handler.
+type sender struct {
+	msgs     chan senderMsg
+	errs     chan error
+	done     chan struct{}
+	dropped  *uint64
+	closeErr error
+}
+
+type senderMsg struct {
+	b   []byte
+	n   int
+	ack chan struct{} // set on the marker queued by sync
+}
+
+// startSender runs h with a queue of size messages, counting the records
+// of dropped messages in dropped.
+func startSender(h senderHandler, size int, dropped *uint64) *sender {
+	s := &sender{
+		msgs:    make(chan senderMsg, size),
+		errs:    make(chan error, 1),
+		done:    make(chan struct{}),
+		dropped: dropped,
+	}
+	go s.run(h)
+	return s
+}
+
+func (s *sender) run(h senderHandler) {
+	ticker := time.NewTicker(sender_retry_interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case m, ok := <-s.msgs:
+			if !ok {
+				s.closeErr = h.shutdown()
+				close(s.done)
+				return
+			}
+			if m.ack != nil {
+				close(m.ack)
+				continue
+			}
+			s.report(h.deliver(m.b, m.n))
+		case <-ticker.C:
+			s.report(h.resend())
+		}
+	}
+}
+
+// put queues msg holding n records, or drops it if the queue is full.
+func (s *sender) put(msg []byte, n int) {
+	select {
+	case s.msgs <- senderMsg{b: msg, n: n}:
+	default:
+		atomic.AddUint64(s.dropped, uint64(n))
+	}
+}
+
+// sync waits until the messages queued so far were handed to the handler,
+// for PANIC and FATAL records which must leave before the process ends.
+func (s *sender) sync() {
+	ack := make(chan struct{})
+	s.msgs <- senderMsg{ack: ack}
+	<-ack
+}
+
+// report keeps the first error until err collects it.
+func (s *sender) report(err error) {
+	if err == nil {
+		return
+	}
+	select {
+	case s.errs <- err:
+	default:
+	}
+}
+
+// err returns an error the handler met since the last call, nil if none.
+func (s *sender) err() error {
+	select {
+	case err := <-s.errs:
+		return err
+	default:
+		return nil
+	}
+}
+
+// close waits for the handler to shut down once the queue is handled.
+func (s *sender) close() error {
+	close(s.msgs)
+	<-s.done
+	return s.closeErr
+}
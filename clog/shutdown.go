@@ -0,0 +1,110 @@
+package clog
+
+import (
+	"context"
+	"io"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const close_timeout_default = 5 * time.Second
+
+// Shutdown writes the records already queued, flushes and closes the
+// writers and stops the writer goroutine, giving up when ctx is done.
+// Later log calls go to the closed output. It may be called many times,
+// from loggers named from l too, and reports the first writer error.
+func (l *Logger) Shutdown(ctx context.Context) error {
+	l.closeOnce.Do(func() {
+		close(l.closing)
+		go func() {
+			// later log calls see closed, wait for those in flight
+			l.state.Lock()
+			l.closed = true
+			l.state.Unlock()
+			l.sending.Wait()
+			l.tunnel <- &textEncoder{done: l.closeDone, stop: true}
+		}()
+	})
+	select {
+	case <-l.closeDone:
+		return l.closeErr
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close is Shutdown waiting at most 5 seconds.
+func (l *Logger) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), close_timeout_default)
+	defer cancel()
+	return l.Shutdown(ctx)
+}
+
+// SetClosedOutput sets where records logged after Close are written,
+// os.Stderr by default, nil drops them.
+func (l *Logger) SetClosedOutput(out io.Writer) {
+	l.closedOut = out
+}
+
+func (l *Logger) closeWriters() error {
+	var first error
+	for _, w := range l.writers {
+		if c, ok := w.(Closer); ok {
+			if err := c.Close(); err != nil {
+				l.handleError(w, OpClose, err)
+				if first == nil {
+					first = err
+				}
+			}
+		}
+	}
+	return first
+}
+
+// ShutdownOnSignal shuts l down, waiting at most timeout, when the process
+// gets one of sigs, SIGINT and SIGTERM if none are given, then exits with
+// 128 plus the signal number through the exit func. stop undoes it.
+func (l *Logger) ShutdownOnSignal(timeout time.Duration, sigs ...os.Signal) (stop func()) {
+	if len(sigs) == 0 {
+		sigs = []os.Signal{os.Interrupt, syscall.SIGTERM}
+	}
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, sigs...)
+	quit := make(chan struct{})
+	go func() {
+		select {
+		case sig := <-ch:
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			l.Shutdown(ctx)
+			cancel()
+			code := 1
+			if s, ok := sig.(syscall.Signal); ok {
+				code = 128 + int(s)
+			}
+			l.exitFunc(code)
+		case <-quit:
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			signal.Stop(ch)
+			close(quit)
+		})
+	}
+}
+
+func Shutdown(ctx context.Context) error {
+	return logger_default.Shutdown(ctx)
+}
+
+func SetClosedOutput(out io.Writer) {
+	logger_default.SetClosedOutput(out)
+}
+
+func ShutdownOnSignal(timeout time.Duration, sigs ...os.Signal) (stop func()) {
+	return logger_default.ShutdownOnSignal(timeout, sigs...)
+}
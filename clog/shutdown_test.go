@@ -0,0 +1,112 @@
+package clog
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type blockingWriter struct {
+	release chan struct{}
+}
+
+func (w *blockingWriter) Init() error { return nil }
+
+func (w *blockingWriter) Write(enc *textEncoder) error {
+	<-w.release
+	return nil
+}
+
+func TestShutdown(t *testing.T) {
+	l := NewLogger()
+	o := NewObserver(TRACE)
+	l.Register(o)
+	l.Info("before")
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Named("db").Close(); err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	l.SetClosedOutput(&out)
+	l.Info("after")
+	l.Sync()
+	if o.Len() != 1 || !strings.Contains(out.String(), "after") {
+		t.Fatalf("got %d records, closed output %q", o.Len(), out.String())
+	}
+
+	// a stuck writer doesn't hold Shutdown past its deadline
+	l = NewLogger()
+	w := &blockingWriter{release: make(chan struct{})}
+	l.Register(w)
+	l.Info("stuck")
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := l.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("got %v", err)
+	}
+	close(w.release)
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+type lockedBuffer struct {
+	mu sync.Mutex
+	b  bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.b.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.b.String()
+}
+
+func TestShutdownReleasesBlockedCalls(t *testing.T) {
+	l := NewLogger()
+	w := &blockingWriter{release: make(chan struct{})}
+	l.Register(w)
+	var out lockedBuffer
+	l.SetClosedOutput(&out)
+	// the writer goroutine is stuck on the first record, the rest fill the
+	// tunnel and the last log call waits for room
+	for i := 0; i <= tunnel_size_default; i++ {
+		l.Info("queued")
+	}
+	logged := make(chan struct{})
+	go func() {
+		l.Info("late")
+		l.Sync()
+		close(logged)
+	}()
+	// let it block on the full tunnel
+	time.Sleep(50 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := l.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("got %v", err)
+	}
+	select {
+	case <-logged:
+	case <-time.After(5 * time.Second):
+		t.Fatal("log call still blocked after Shutdown")
+	}
+	if !strings.Contains(out.String(), "late") {
+		t.Fatalf("closed output %q", out.String())
+	}
+	close(w.release)
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
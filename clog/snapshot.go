@@ -0,0 +1,260 @@
+package clog
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sync/atomic"
+	"time"
+)
+
+// fieldReader is implemented by the writers encoding the fields of
+// enc.rec themselves, possibly after the log call returned. Fields are
+// snapshotted only while one of them is registered.
+type fieldReader interface {
+	readsFields()
+}
+
+// trackFieldReader counts w among the registered fieldReaders, l.mu held.
+func (l *Logger) trackFieldReader(w Writer, delta int32) {
+	if _, ok := w.(fieldReader); ok {
+		atomic.AddInt32(&l.fieldReaders, delta)
+	}
+}
+
+// snapshotFields copies fields so that writers encoding the record on
+// another goroutine see the values as they were at the log call: Stringers
+// and reflected values are rendered, marshalers replayed into captured
+// values. The caller may reuse the slice and change the objects in it.
+func snapshotFields(fields []Field) []Field {
+	if len(fields) == 0 {
+		return fields
+	}
+	out := make([]Field, len(fields))
+	for i, f := range fields {
+		switch f.fieldType {
+		case stringerType:
+			f = String(f.key, f.obj.(fmt.Stringer).String())
+		case objectType:
+			f.obj = newReflected(f.obj)
+		case arrayType:
+			f.obj = captureArray(f.obj.(ArrayMarshaler))
+		case objectMarshalerType:
+			f.obj = captureObject(f.obj.(ObjectMarshaler))
+		}
+		out[i] = f
+	}
+	return out
+}
+
+// reflected is a value already put through %+v and encoding/json.
+type reflected struct {
+	text string
+	raw  []byte
+	err  error
+}
+
+func newReflected(val interface{}) *reflected {
+	r := &reflected{text: fmt.Sprintf("%+v", val)}
+	r.raw, r.err = json.Marshal(val)
+	return r
+}
+
+// String is what %+v prints in the text format.
+func (r *reflected) String() string {
+	return r.text
+}
+
+// capturedObject holds the pairs an ObjectMarshaler wrote and the error it
+// returned. Nested objects keep no error, their marshaler already saw it.
+type capturedObject struct {
+	fields []Field
+	err    error
+}
+
+func captureObject(obj ObjectMarshaler) *capturedObject {
+	enc := &captureEncoder{}
+	err := obj.MarshalLogObject(enc)
+	return &capturedObject{fields: enc.fields, err: err}
+}
+
+func (c *capturedObject) MarshalLogObject(enc ObjectEncoder) error {
+	for i := range c.fields {
+		c.fields[i].replay(enc)
+	}
+	return c.err
+}
+
+// capturedArray is capturedObject for an ArrayMarshaler.
+type capturedArray struct {
+	values []Field
+	err    error
+}
+
+func captureArray(arr ArrayMarshaler) *capturedArray {
+	enc := &captureEncoder{}
+	err := arr.MarshalLogArray(enc)
+	return &capturedArray{values: enc.fields, err: err}
+}
+
+func (c *capturedArray) MarshalLogArray(enc ArrayEncoder) error {
+	for i := range c.values {
+		c.values[i].replayValue(enc)
+	}
+	return c.err
+}
+
+// captureEncoder records what a marshaler writes as fields, array values
+// with an empty key.
+type captureEncoder struct {
+	fields []Field
+}
+
+func (enc *captureEncoder) AddString(key, val string) {
+	enc.fields = append(enc.fields, String(key, val))
+}
+
+func (enc *captureEncoder) AddInt64(key string, val int64) {
+	enc.fields = append(enc.fields, Int64(key, val))
+}
+
+func (enc *captureEncoder) AddUint64(key string, val uint64) {
+	enc.fields = append(enc.fields, Uint64(key, val))
+}
+
+func (enc *captureEncoder) AddFloat64(key string, val float64) {
+	enc.fields = append(enc.fields, Float64(key, val))
+}
+
+func (enc *captureEncoder) AddBool(key string, val bool) {
+	enc.fields = append(enc.fields, Bool(key, val))
+}
+
+func (enc *captureEncoder) AddTime(key string, val time.Time) {
+	enc.fields = append(enc.fields, Time(key, val))
+}
+
+func (enc *captureEncoder) AddDuration(key string, val time.Duration) {
+	enc.fields = append(enc.fields, Duration(key, val))
+}
+
+func (enc *captureEncoder) AddObject(key string, obj ObjectMarshaler) error {
+	c := captureObject(obj)
+	err := c.err
+	c.err = nil
+	enc.fields = append(enc.fields, Field{key: key, fieldType: objectMarshalerType, obj: c})
+	return err
+}
+
+func (enc *captureEncoder) AddArray(key string, arr ArrayMarshaler) error {
+	c := captureArray(arr)
+	err := c.err
+	c.err = nil
+	enc.fields = append(enc.fields, Field{key: key, fieldType: arrayType, obj: c})
+	return err
+}
+
+func (enc *captureEncoder) AddReflected(key string, val interface{}) error {
+	r := newReflected(val)
+	enc.fields = append(enc.fields, Field{key: key, fieldType: objectType, obj: r})
+	return r.err
+}
+
+func (enc *captureEncoder) OpenNamespace(key string) {
+	enc.fields = append(enc.fields, Namespace(key))
+}
+
+func (enc *captureEncoder) AppendString(val string) {
+	enc.AddString("", val)
+}
+
+func (enc *captureEncoder) AppendInt64(val int64) {
+	enc.AddInt64("", val)
+}
+
+func (enc *captureEncoder) AppendUint64(val uint64) {
+	enc.AddUint64("", val)
+}
+
+func (enc *captureEncoder) AppendFloat64(val float64) {
+	enc.AddFloat64("", val)
+}
+
+func (enc *captureEncoder) AppendBool(val bool) {
+	enc.AddBool("", val)
+}
+
+func (enc *captureEncoder) AppendTime(val time.Time) {
+	enc.AddTime("", val)
+}
+
+func (enc *captureEncoder) AppendDuration(val time.Duration) {
+	enc.AddDuration("", val)
+}
+
+func (enc *captureEncoder) AppendObject(obj ObjectMarshaler) error {
+	return enc.AddObject("", obj)
+}
+
+func (enc *captureEncoder) AppendArray(arr ArrayMarshaler) error {
+	return enc.AddArray("", arr)
+}
+
+func (enc *captureEncoder) AppendReflected(val interface{}) error {
+	return enc.AddReflected("", val)
+}
+
+// replay writes a captured pair into enc. Errors were reported when the
+// pair was captured.
+func (f *Field) replay(enc ObjectEncoder) {
+	switch f.fieldType {
+	case stringType:
+		enc.AddString(f.key, f.str)
+	case int64Type:
+		enc.AddInt64(f.key, f.ival)
+	case uintType:
+		enc.AddUint64(f.key, uint64(f.ival))
+	case floatType:
+		enc.AddFloat64(f.key, math.Float64frombits(uint64(f.ival)))
+	case boolType:
+		enc.AddBool(f.key, f.ival == 1)
+	case timeType:
+		enc.AddTime(f.key, f.obj.(time.Time))
+	case durationType:
+		enc.AddDuration(f.key, time.Duration(f.ival))
+	case objectMarshalerType:
+		enc.AddObject(f.key, f.obj.(ObjectMarshaler))
+	case arrayType:
+		enc.AddArray(f.key, f.obj.(ArrayMarshaler))
+	case objectType:
+		enc.AddReflected(f.key, f.obj)
+	case namespaceType:
+		enc.OpenNamespace(f.key)
+	}
+}
+
+// replayValue is replay for a captured array value.
+func (f *Field) replayValue(enc ArrayEncoder) {
+	switch f.fieldType {
+	case stringType:
+		enc.AppendString(f.str)
+	case int64Type:
+		enc.AppendInt64(f.ival)
+	case uintType:
+		enc.AppendUint64(uint64(f.ival))
+	case floatType:
+		enc.AppendFloat64(math.Float64frombits(uint64(f.ival)))
+	case boolType:
+		enc.AppendBool(f.ival == 1)
+	case timeType:
+		enc.AppendTime(f.obj.(time.Time))
+	case durationType:
+		enc.AppendDuration(time.Duration(f.ival))
+	case objectMarshalerType:
+		enc.AppendObject(f.obj.(ObjectMarshaler))
+	case arrayType:
+		enc.AppendArray(f.obj.(ArrayMarshaler))
+	case objectType:
+		enc.AppendReflected(f.obj)
+	}
+}
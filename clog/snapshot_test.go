@@ -0,0 +1,116 @@
+package clog
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+type counter struct {
+	n int
+}
+
+func (c *counter) String() string {
+	return strings.Repeat("x", c.n)
+}
+
+func (c *counter) MarshalLogObject(enc ObjectEncoder) error {
+	enc.AddInt64("n", int64(c.n))
+	if c.n > 1 {
+		return errors.New("too many")
+	}
+	return nil
+}
+
+// recordChan passes the records written to it on to the test, reading
+// their fields later like the structured writers.
+type recordChan chan Record
+
+func (c recordChan) Init() error { return nil }
+
+func (c recordChan) readsFields() {}
+
+func (c recordChan) Write(enc *textEncoder) error {
+	c <- *enc.rec
+	return nil
+}
+
+func TestFieldsSnapshotAtLogCall(t *testing.T) {
+	l := NewLogger()
+	c := make(recordChan, 1)
+	l.Register(c)
+
+	cnt := &counter{n: 1}
+	m := map[string]int{"a": 1}
+	tags := []string{"a"}
+	fields := []Field{
+		Stringer("s", cnt),
+		Object("c", cnt),
+		Object("m", m),
+		Strings("tags", tags),
+		Dict("d", Object("m", m)),
+	}
+	l.deliverRecordToWriterHight(INFO, "m", fields...)
+	cnt.n = 2
+	m["a"] = 2
+	tags[0] = "b"
+	fields[0] = String("s", "changed")
+
+	r := <-c
+	text, js := render("m", r.fields...)
+	if want := "] m s=x c={n=1} m=map[a:1] tags=[a] d={m=map[a:1]}\n"; !strings.HasSuffix(text, want) {
+		t.Errorf("text %q, want suffix %q", text, want)
+	}
+	if want := `"s":"x","c":{"n":1},"m":{"a":1},"tags":["a"],"d":{"m":{"a":1}}}`; !strings.HasSuffix(strings.TrimSuffix(js, "\n"), want) {
+		t.Errorf("json %q, want suffix %q", js, want)
+	}
+}
+
+func TestSnapshotKeepsMarshalerErrors(t *testing.T) {
+	c := &counter{n: 2}
+	fields := snapshotFields([]Field{Object("c", c), Dict("d", Object("c", c))})
+	c.n = 0
+	text, js := render("m", fields...)
+	if want := `] m c={n=2} cError=too\ many d={c={n=2}\ cError=too\ many}` + "\n"; !strings.HasSuffix(text, want) {
+		t.Errorf("text %q, want suffix %q", text, want)
+	}
+	if want := `"c":{"n":2},"cError":"too many","d":{"c":{"n":2},"cError":"too many"}}`; !strings.HasSuffix(strings.TrimSuffix(js, "\n"), want) {
+		t.Errorf("json %q, want suffix %q", js, want)
+	}
+}
+
+type jsonCounter struct {
+	calls *int
+}
+
+func (c jsonCounter) MarshalJSON() ([]byte, error) {
+	*c.calls++
+	return []byte("1"), nil
+}
+
+func TestSnapshotOnlyForFieldReaders(t *testing.T) {
+	l := NewLogger()
+	defer l.Close()
+	calls := 0
+	logged := func() int {
+		calls = 0
+		l.HighInfo("m", Object("c", jsonCounter{&calls}))
+		l.Sync()
+		return calls
+	}
+
+	if n := logged(); n != 0 {
+		t.Fatalf("snapshot without a field reader marshaled %d times", n)
+	}
+	o := NewObserver(TRACE)
+	l.Register(o)
+	if n := logged(); n != 1 {
+		t.Fatalf("snapshot for an observer marshaled %d times", n)
+	}
+	if err := l.Unregister(o); err != nil {
+		t.Fatal(err)
+	}
+	if n := logged(); n != 0 {
+		t.Fatalf("snapshot after unregistering marshaled %d times", n)
+	}
+}
@@ -0,0 +1,65 @@
+package clog
+
+import (
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// clogPackage is the import path of this package, used to trim its frames
+// from the top of captured stacks.
+var clogPackage = reflect.TypeOf(Logger{}).PkgPath()
+
+// takeStacktrace formats the stack of the calling goroutine as
+// "function\n\tfile:line" lines, without the leading frames of clog itself.
+func takeStacktrace() string {
+	pcs := make([]uintptr, 64)
+	for {
+		n := runtime.Callers(2, pcs)
+		if n < len(pcs) {
+			pcs = pcs[:n]
+			break
+		}
+		pcs = make([]uintptr, 2*len(pcs))
+	}
+
+	var b strings.Builder
+	frames := runtime.CallersFrames(pcs)
+	trimming := true
+	for {
+		frame, more := frames.Next()
+		if trimming && isClogFrame(frame) {
+			if !more {
+				break
+			}
+			continue
+		}
+		trimming = false
+		if b.Len() > 0 {
+			b.WriteByte('\n')
+		}
+		b.WriteString(frame.Function)
+		b.WriteString("\n\t")
+		b.WriteString(frame.File)
+		b.WriteByte(':')
+		b.WriteString(strconv.Itoa(frame.Line))
+		if !more {
+			break
+		}
+	}
+	return b.String()
+}
+
+func isClogFrame(frame runtime.Frame) bool {
+	if strings.HasSuffix(frame.File, "_test.go") {
+		return false
+	}
+	fn := frame.Function
+	if !strings.HasPrefix(fn, clogPackage) {
+		return false
+	}
+	// reject packages sharing the prefix, e.g. clog/clogtest
+	rest := fn[len(clogPackage):]
+	return strings.HasPrefix(rest, ".")
+}
@@ -0,0 +1,54 @@
+package clog
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+const testFuncPrefix = "github.com/forge1yc/clog/clog.Test"
+
+func TestStackField(t *testing.T) {
+	f := Stack("st")
+	if !strings.HasPrefix(f.str, testFuncPrefix+"StackField\n\t") {
+		t.Fatalf("clog frames not trimmed:\n%s", f.str)
+	}
+	if !strings.Contains(f.str, "stacktrace_test.go:") {
+		t.Fatalf("no file:line:\n%s", f.str)
+	}
+
+	text, js := render("m", f)
+	if !strings.Contains(text, "] m st=\n"+testFuncPrefix+"StackField\n\t") {
+		t.Fatalf("text %q", text)
+	}
+	var doc map[string]interface{}
+	if err := json.Unmarshal([]byte(js), &doc); err != nil {
+		t.Fatalf("%v: %s", err, js)
+	}
+	if doc["st"] != f.str {
+		t.Fatalf("json %q", js)
+	}
+}
+
+func TestStacktraceLevel(t *testing.T) {
+	l := NewLogger()
+	l.SetLevel(TRACE)
+	c := make(lineChan, 16)
+	l.Register(c)
+	l.SetStacktraceLevel(ERROR)
+	l.Info("no stack")
+	l.Public("no stack")
+	l.Error("stack")
+	l.SetStacktraceLevel(-1)
+	l.Error("no stack")
+
+	for _, line := range c.next(t, 4) {
+		stack := strings.Contains(line, "stacktrace=")
+		if stack != strings.Contains(line, "] stack ") {
+			t.Fatalf("%q has stack %v", line, stack)
+		}
+		if stack && !strings.Contains(line, "] stack stacktrace=\n"+testFuncPrefix+"StacktraceLevel\n\t") {
+			t.Fatalf("stack starts in clog: %q", line)
+		}
+	}
+}
@@ -0,0 +1,465 @@
+package clog
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Syslog facilities, see RFC 5424 section 6.2.1.
+const (
+	LOG_KERN = iota
+	LOG_USER
+	LOG_MAIL
+	LOG_DAEMON
+	LOG_AUTH
+	LOG_SYSLOG
+	LOG_LPR
+	LOG_NEWS
+	LOG_UUCP
+	LOG_CRON
+	LOG_AUTHPRIV
+	LOG_FTP
+	LOG_LOCAL0 = iota + 4
+	LOG_LOCAL1
+	LOG_LOCAL2
+	LOG_LOCAL3
+	LOG_LOCAL4
+	LOG_LOCAL5
+	LOG_LOCAL6
+	LOG_LOCAL7
+)
+
+var syslogFacilities = map[string]int{
+	"kern": LOG_KERN, "user": LOG_USER, "mail": LOG_MAIL, "daemon": LOG_DAEMON,
+	"auth": LOG_AUTH, "syslog": LOG_SYSLOG, "lpr": LOG_LPR, "news": LOG_NEWS,
+	"uucp": LOG_UUCP, "cron": LOG_CRON, "authpriv": LOG_AUTHPRIV, "ftp": LOG_FTP,
+	"local0": LOG_LOCAL0, "local1": LOG_LOCAL1, "local2": LOG_LOCAL2,
+	"local3": LOG_LOCAL3, "local4": LOG_LOCAL4, "local5": LOG_LOCAL5,
+	"local6": LOG_LOCAL6, "local7": LOG_LOCAL7,
+}
+
+// ParseFacility returns the facility named as in syslog.conf, e.g. "local0".
+func ParseFacility(name string) (int, bool) {
+	facility, ok := syslogFacilities[strings.ToLower(strings.TrimSpace(name))]
+	return facility, ok
+}
+
+// Syslog severities.
+const (
+	LOG_EMERG = iota
+	LOG_ALERT
+	LOG_CRIT
+	LOG_ERR
+	LOG_WARNING
+	LOG_NOTICE
+	LOG_INFO
+	LOG_DEBUG
+)
+
+// syslogSDID is the SD-ID of the structured data element holding fields,
+// 32473 being the enterprise number reserved for documentation.
+const syslogSDID = "clog@32473"
+
+const syslog_pending_bytes_cnt = 1 << 20
+const syslog_queue_cnt = 1024
+
+// syslogSockets are tried in order for a local daemon.
+var syslogSockets = []string{"/dev/log", "/var/run/syslog", "/var/run/log"}
+
+// SyslogWriter sends records to a syslog daemon over a unix socket, UDP or
+// TCP, as RFC 5424 with fields in structured data, or as RFC 3164. A
+// goroutine of the writer dials and sends, records finding its queue full
+// are dropped. While the daemon is unreachable records are kept in memory,
+// the oldest dropped past the buffer size, and sent once a reconnection
+// succeeds.
+type SyslogWriter struct {
+	writeCount
+
+	network  string
+	raddr    string
+	tag      string
+	hostname string
+	facility int
+	rfc3164  bool
+	public   int
+
+	logLevelFloor int
+	logLevelCeil  int
+
+	buf    []byte
+	sender *sender
+
+	// owned by the sender goroutine
+	conn         net.Conn
+	stream       bool
+	dialTimeout  time.Duration
+	writeTimeout time.Duration
+	retry        backoff
+
+	pending      [][]byte
+	pendingBytes int
+	maxPending   int
+	dropped      uint64
+}
+
+// NewSyslogWriter returns a writer to raddr over network, "unixgram",
+// "unix", "udp" or "tcp". An empty network and raddr use the local daemon.
+// tag defaults to the program name.
+func NewSyslogWriter(network, raddr, tag string) *SyslogWriter {
+	if tag == "" {
+		tag = path.Base(os.Args[0])
+	}
+	return &SyslogWriter{
+		network:      network,
+		raddr:        raddr,
+		tag:          tag,
+		facility:     LOG_USER,
+		public:       LOG_NOTICE,
+		logLevelCeil: PUBLIC,
+		dialTimeout:  time.Second,
+		writeTimeout: 5 * time.Second,
+		retry:        newBackoff(100*time.Millisecond, 30*time.Second),
+		maxPending:   syslog_pending_bytes_cnt,
+	}
+}
+
+func (w *SyslogWriter) SetFacility(facility int) {
+	w.facility = facility
+}
+
+// SetRFC3164 switches to the legacy BSD format.
+func (w *SyslogWriter) SetRFC3164(on bool) {
+	w.rfc3164 = on
+}
+
+// SetPublicSeverity sets the severity of PUBLIC records, LOG_NOTICE by
+// default.
+func (w *SyslogWriter) SetPublicSeverity(severity int) {
+	w.public = severity
+}
+
+func (w *SyslogWriter) SetLogLevelFloor(floor int) {
+	w.logLevelFloor = floor
+}
+
+func (w *SyslogWriter) SetLogLevelCeil(ceil int) {
+	w.logLevelCeil = ceil
+}
+
+func (w *SyslogWriter) SetWriteTimeout(d time.Duration) {
+	w.writeTimeout = d
+}
+
+// SetBufferSize bounds the bytes kept while the daemon is unreachable.
+func (w *SyslogWriter) SetBufferSize(n int) {
+	w.maxPending = n
+}
+
+// Dropped returns how many records were dropped from a full buffer.
+func (w *SyslogWriter) Dropped() uint64 {
+	return atomic.LoadUint64(&w.dropped)
+}
+
+func (w *SyslogWriter) Init() error {
+	switch w.network {
+	case "", "unixgram", "unix", "udp", "udp4", "udp6", "tcp", "tcp4", "tcp6":
+	default:
+		return errors.New("Invalid syslog network (" + w.network + ")")
+	}
+	if w.network != "" && w.raddr == "" {
+		return errors.New("syslog address missing")
+	}
+	if w.hostname == "" {
+		w.hostname, _ = os.Hostname()
+		if w.hostname == "" {
+			w.hostname = "-"
+		}
+	}
+	w.sender = startSender(w, syslog_queue_cnt, &w.dropped)
+	return nil
+}
+
+func (w *SyslogWriter) dial() (net.Conn, error) {
+	if w.network != "" {
+		return net.DialTimeout(w.network, w.raddr, w.dialTimeout)
+	}
+	var err error
+	for _, addr := range syslogSockets {
+		for _, network := range []string{"unixgram", "unix"} {
+			var conn net.Conn
+			if conn, err = net.DialTimeout(network, addr, w.dialTimeout); err == nil {
+				return conn, nil
+			}
+		}
+	}
+	return nil, err
+}
+
+func (w *SyslogWriter) connect() bool {
+	if w.conn != nil {
+		return true
+	}
+	now := time.Now()
+	if !w.retry.ready(now) {
+		return false
+	}
+	conn, err := w.dial()
+	if err != nil {
+		w.retry.fail(now)
+		return false
+	}
+	w.retry.reset()
+	w.conn = conn
+	switch conn.RemoteAddr().Network() {
+	case "tcp", "tcp4", "tcp6", "unix":
+		w.stream = true
+	default:
+		w.stream = false
+	}
+	return true
+}
+
+func (w *SyslogWriter) accepts(level int) bool {
+	return levelBetween(level, w.logLevelFloor, w.logLevelCeil)
+}
+
+func (w *SyslogWriter) readsFields() {}
+
+func (w *SyslogWriter) Write(enc *textEncoder) error {
+	r := enc.rec
+	if r == nil || !w.accepts(r.level) {
+		return nil
+	}
+	if w.rfc3164 {
+		w.buf = w.format3164(w.buf[:0], r)
+	} else {
+		w.buf = w.format5424(w.buf[:0], r)
+	}
+	msg := make([]byte, len(w.buf))
+	copy(msg, w.buf)
+	w.sender.put(msg, 1)
+	if r.level == PANIC || r.level == FATAL {
+		w.sender.sync()
+	}
+	return nil
+}
+
+// Flush returns an error the last sends met.
+func (w *SyslogWriter) Flush() error {
+	if w.sender == nil {
+		return nil
+	}
+	return w.sender.err()
+}
+
+// deliver queues msg behind the buffered records and sends them, an
+// unreachable daemon is not an error, records wait for it.
+func (w *SyslogWriter) deliver(msg []byte, n int) error {
+	w.pending = append(w.pending, msg)
+	w.pendingBytes += len(msg)
+	for w.pendingBytes > w.maxPending && len(w.pending) > 1 {
+		w.pendingBytes -= len(w.pending[0])
+		w.pending[0] = nil
+		w.pending = w.pending[1:]
+		atomic.AddUint64(&w.dropped, 1)
+	}
+	return w.resend()
+}
+
+// resend sends the buffered records once the daemon is reachable again.
+func (w *SyslogWriter) resend() error {
+	if len(w.pending) == 0 || !w.connect() {
+		return nil
+	}
+	for len(w.pending) > 0 {
+		if err := w.send(w.pending[0]); err != nil {
+			w.conn.Close()
+			w.conn = nil
+			w.retry.fail(time.Now())
+			return err
+		}
+		w.pendingBytes -= len(w.pending[0])
+		w.pending[0] = nil
+		w.pending = w.pending[1:]
+	}
+	return nil
+}
+
+func (w *SyslogWriter) send(msg []byte) error {
+	var frame []byte
+	switch {
+	case !w.stream:
+		frame = msg
+	case w.rfc3164:
+		frame = append(msg[:len(msg):len(msg)], '\n')
+	default:
+		// RFC 6587 octet counting
+		frame = strconv.AppendInt(nil, int64(len(msg)), 10)
+		frame = append(frame, ' ')
+		frame = append(frame, msg...)
+	}
+	if w.writeTimeout > 0 {
+		w.conn.SetWriteDeadline(time.Now().Add(w.writeTimeout))
+	}
+	if _, err := w.conn.Write(frame); err != nil {
+		return err
+	}
+	w.count(1, len(frame))
+	return nil
+}
+
+// Close tries a last time to send the buffered records and closes the
+// connection.
+func (w *SyslogWriter) Close() error {
+	if w.sender == nil {
+		return nil
+	}
+	err := w.sender.close()
+	w.sender = nil
+	return err
+}
+
+// shutdown tries the buffered records once more, those left are dropped.
+func (w *SyslogWriter) shutdown() error {
+	w.resend()
+	atomic.AddUint64(&w.dropped, uint64(len(w.pending)))
+	w.pending, w.pendingBytes = nil, 0
+	if w.conn == nil {
+		return nil
+	}
+	err := w.conn.Close()
+	w.conn = nil
+	return err
+}
+
+func (w *SyslogWriter) severity(level int) int {
+	switch level {
+	case TRACE, DEBUG:
+		return LOG_DEBUG
+	case INFO:
+		return LOG_INFO
+	case WARNING:
+		return LOG_WARNING
+	case ERROR:
+		return LOG_ERR
+	case PANIC, FATAL:
+		return LOG_CRIT
+	}
+	return w.public
+}
+
+func (w *SyslogWriter) appendPRI(b []byte, level int) []byte {
+	b = append(b, '<')
+	b = strconv.AppendInt(b, int64(w.facility*8+w.severity(level)), 10)
+	return append(b, '>')
+}
+
+// format5424 writes
+// <PRI>1 TIMESTAMP HOSTNAME APP-NAME PROCID - [clog@32473 caller=".." k="v"] MSG
+func (w *SyslogWriter) format5424(b []byte, r *Record) []byte {
+	b = w.appendPRI(b, r.level)
+	b = append(b, '1', ' ')
+	b = r.time.AppendFormat(b, "2006-01-02T15:04:05.000000Z07:00")
+	b = append(b, ' ')
+	b = appendSyslogName(b, w.hostname, 255)
+	b = append(b, ' ')
+	b = appendSyslogName(b, w.tag, 48)
+	b = append(b, ' ')
+	b = strconv.AppendInt(b, int64(os.Getpid()), 10)
+	b = append(b, " - ["...)
+	b = append(b, syslogSDID...)
+	b = append(b, ` caller="`...)
+	b = appendSDValue(b, r.code+":"+strconv.Itoa(r.line))
+	b = append(b, '"')
+	if r.name != "" {
+		b = append(b, ` logger="`...)
+		b = appendSDValue(b, r.name)
+		b = append(b, '"')
+	}
+	for i := range r.fields {
+		f := &r.fields[i]
+		if f.fieldType == namespaceType {
+			continue
+		}
+		b = append(b, ' ')
+		b = appendSyslogName(b, f.key, 32)
+		b = append(b, '=', '"')
+		b = appendSDValue(b, string(f.WriteValue(nil)))
+		b = append(b, '"')
+	}
+	b = append(b, "] "...)
+	return appendSyslogMessage(b, r)
+}
+
+// format3164 writes <PRI>Mmm dd hh:mm:ss HOSTNAME TAG[PID]: MSG k=v
+func (w *SyslogWriter) format3164(b []byte, r *Record) []byte {
+	b = w.appendPRI(b, r.level)
+	b = r.time.AppendFormat(b, time.Stamp)
+	b = append(b, ' ')
+	b = append(b, w.hostname...)
+	b = append(b, ' ')
+	b = append(b, w.tag...)
+	b = append(b, '[')
+	b = strconv.AppendInt(b, int64(os.Getpid()), 10)
+	b = append(b, "]: "...)
+	b = appendSyslogMessage(b, r)
+	for i := range r.fields {
+		f := &r.fields[i]
+		if f.fieldType == namespaceType {
+			continue
+		}
+		b = append(b, ' ')
+		b = append(b, f.key...)
+		b = append(b, '=')
+		b = appendEscaped(b, string(f.WriteValue(nil)), ' ')
+	}
+	return b
+}
+
+// appendSyslogMessage appends the message of r escaped, unless it was
+// when the record was made.
+func appendSyslogMessage(b []byte, r *Record) []byte {
+	if r.escaped {
+		return append(b, r.message()...)
+	}
+	return appendEscaped(b, r.message(), 0)
+}
+
+// appendSyslogName appends s restricted to the printable US-ASCII allowed
+// in header fields and SD names, "-" when nothing is left.
+func appendSyslogName(b []byte, s string, max int) []byte {
+	n := 0
+	for i := 0; i < len(s) && n < max; i++ {
+		c := s[i]
+		if c < 33 || c > 126 || c == '=' || c == ']' || c == '"' {
+			continue
+		}
+		b = append(b, c)
+		n++
+	}
+	if n == 0 {
+		b = append(b, '-')
+	}
+	return b
+}
+
+// appendSDValue escapes '"', '\' and ']' of an SD param value.
+func appendSDValue(b []byte, s string) []byte {
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '"', '\\', ']':
+			b = append(b, '\\', c)
+		case '\n':
+			b = append(b, '\\', 'n')
+		default:
+			b = append(b, c)
+		}
+	}
+	return b
+}
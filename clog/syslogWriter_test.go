@@ -0,0 +1,105 @@
+package clog
+
+import (
+	"bufio"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSyslogWriterUDP(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+
+	w := NewSyslogWriter("udp", pc.LocalAddr().String(), "app")
+	w.SetFacility(LOG_LOCAL0)
+	if err := w.Init(); err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	r := &Record{
+		time:   time.Now(),
+		code:   "main.go",
+		line:   7,
+		info:   "user login",
+		level:  WARNING,
+		fields: []Field{String("user", `a"b]`), Int("id", 3)},
+	}
+	if err := w.Write(&textEncoder{rec: r}); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, 1024)
+	pc.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := pc.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := string(buf[:n])
+	// local0 * 8 + warning
+	if !strings.HasPrefix(msg, "<132>1 ") {
+		t.Fatalf("unexpected header: %q", msg)
+	}
+	want := ` app `
+	if !strings.Contains(msg, want) {
+		t.Fatalf("missing tag in %q", msg)
+	}
+	want = `[clog@32473 caller="main.go:7" user="a\"b\]" id="3"] user login`
+	if !strings.HasSuffix(msg, want) {
+		t.Fatalf("got %q, want suffix %q", msg, want)
+	}
+}
+
+func TestSyslogWriterWaitsForDaemon(t *testing.T) {
+	// reserve a port with nobody listening yet
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	w := NewSyslogWriter("tcp", addr, "app")
+	w.SetRFC3164(true)
+	w.retry = newBackoff(time.Millisecond, time.Millisecond)
+	if err := w.Init(); err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	for i := 0; i < 3; i++ {
+		r := &Record{time: time.Now(), info: "record " + strconv.Itoa(i), level: INFO}
+		if err := w.Write(&textEncoder{rec: r}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ln, err = net.Listen("tcp", addr)
+	if err != nil {
+		t.Skip("port taken again:", err)
+	}
+	defer ln.Close()
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	s := bufio.NewScanner(conn)
+	for i := 0; i < 3; i++ {
+		if !s.Scan() {
+			t.Fatal(s.Err())
+		}
+		if want := "]: record " + strconv.Itoa(i); !strings.HasSuffix(s.Text(), want) {
+			t.Fatalf("got %q, want suffix %q", s.Text(), want)
+		}
+	}
+	if w.Dropped() != 0 {
+		t.Fatalf("dropped %d", w.Dropped())
+	}
+}
@@ -0,0 +1,111 @@
+package clog
+
+import (
+	"errors"
+	"path"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// vmoduleRule lowers the level for source files whose base name matches
+// pattern, with or without the ".go" suffix.
+type vmoduleRule struct {
+	pattern string
+	level   int
+}
+
+type vmodule struct {
+	rules []vmoduleRule
+	min   int      // rank of the most verbose level of all rules
+	cache sync.Map // call site pc -> level, levelUnset when no rule matches
+}
+
+// parseVModule parses a glog style spec such as
+// "fileWriter.go=trace,handler*=debug".
+func parseVModule(spec string) (*vmodule, error) {
+	vm := &vmodule{min: levelRank(PUBLIC) + 1}
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		i := strings.LastIndexByte(item, '=')
+		if i <= 0 {
+			return nil, errors.New("Invalid vmodule item (" + item + ")")
+		}
+		pattern := strings.TrimSpace(item[:i])
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, errors.New("Invalid vmodule pattern (" + pattern + ")")
+		}
+		lvl, ok := ParseLevel(item[i+1:])
+		if !ok {
+			return nil, errors.New("Invalid vmodule level (" + item + ")")
+		}
+		vm.rules = append(vm.rules, vmoduleRule{pattern: pattern, level: lvl})
+		if levelRank(lvl) < vm.min {
+			vm.min = levelRank(lvl)
+		}
+	}
+	if len(vm.rules) == 0 {
+		return nil, nil
+	}
+	return vm, nil
+}
+
+// levelFor returns the level of the first rule matching the file of pc.
+func (vm *vmodule) levelFor(pc uintptr) int {
+	if lvl, ok := vm.cache.Load(pc); ok {
+		return lvl.(int)
+	}
+	frame, _ := runtime.CallersFrames([]uintptr{pc}).Next()
+	base := path.Base(frame.File)
+	short := strings.TrimSuffix(base, ".go")
+	lvl := levelUnset
+	for _, rule := range vm.rules {
+		if ok, _ := path.Match(rule.pattern, base); ok {
+			lvl = rule.level
+			break
+		}
+		if ok, _ := path.Match(rule.pattern, short); ok {
+			lvl = rule.level
+			break
+		}
+	}
+	vm.cache.Store(pc, lvl)
+	return lvl
+}
+
+// SetVModule sets per source file levels that may only be more verbose than
+// the logger level, e.g. "fileWriter.go=trace,handler*=debug". An empty
+// spec removes them.
+func (l *Logger) SetVModule(spec string) error {
+	vm, err := parseVModule(spec)
+	if err != nil {
+		return err
+	}
+	l.vmodule.Store(vm)
+	return nil
+}
+
+// enabledAt is enabled extended by the vmodule rules for the call site
+// skip frames above the caller of enabledAt, counted like runtime.Caller.
+func (l *Logger) enabledAt(level int, skip int) bool {
+	if l.enabled(level) {
+		return true
+	}
+	vm, _ := l.vmodule.Load().(*vmodule)
+	if vm == nil || levelRank(level) < vm.min {
+		return false
+	}
+	var pcs [1]uintptr
+	if runtime.Callers(skip+2, pcs[:]) == 0 {
+		return false
+	}
+	lvl := vm.levelFor(pcs[0])
+	return lvl != levelUnset && atLeast(level, lvl)
+}
+
+func SetVModule(spec string) error {
+	return logger_default.SetVModule(spec)
+}
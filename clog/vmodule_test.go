@@ -0,0 +1,117 @@
+package clog
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// lineChan passes the lines written to it on to the test.
+type lineChan chan string
+
+func (c lineChan) Init() error { return nil }
+
+func (c lineChan) Write(enc *textEncoder) error {
+	c <- string(enc.bytes)
+	return nil
+}
+
+// next waits for the lines of n records.
+func (c lineChan) next(t *testing.T, n int) []string {
+	var lines []string
+	for len(lines) < n {
+		select {
+		case line := <-c:
+			lines = append(lines, line)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("got %d records, want %d: %q", len(lines), n, lines)
+		}
+	}
+	return lines
+}
+
+func newVModuleLogger(t *testing.T, spec string) (*Logger, lineChan) {
+	l := NewLogger()
+	l.SetLevel(INFO)
+	c := make(lineChan, 16)
+	l.Register(c)
+	if err := l.SetVModule(spec); err != nil {
+		t.Fatal(err)
+	}
+	return l, c
+}
+
+func TestVModuleEntryPoints(t *testing.T) {
+	l, c := newVModuleLogger(t, "vmodule_test=trace")
+	l.Trace("trace")
+	l.HighTrace("high")
+	l.TraceSort([]string{"k"}, []interface{}{"v"})
+	l.Named("db").Debug("named")
+
+	for _, line := range c.next(t, 4) {
+		if !strings.Contains(line, "[vmodule_test.go:") {
+			t.Errorf("%q not logged from vmodule_test.go", line)
+		}
+	}
+}
+
+func TestVModulePackageEntryPoints(t *testing.T) {
+	// keep away from the writers other tests set up on the default logger
+	saved := logger_default
+	logger_default = NewLogger()
+	defer func() {
+		logger_default = saved
+	}()
+	c := make(lineChan, 16)
+	Register(c)
+	if err := SetVModule("vmodule_test.go=debug"); err != nil {
+		t.Fatal(err)
+	}
+	SetLevel(ERROR)
+
+	Debug("debug")
+	ListDebug([]string{"k"}, []interface{}{"v"})
+	TraceDebug("42", []string{"k"}, []interface{}{"v"})
+	lc := NewLoggerContext("42")
+	lc.LogDebug([]string{"k"}, []interface{}{"v"})
+	Trace("too verbose")
+	Error("end")
+
+	lines := c.next(t, 5)
+	for _, line := range lines[:4] {
+		if !strings.Contains(line, "[vmodule_test.go:") {
+			t.Errorf("%q not logged from vmodule_test.go", line)
+		}
+	}
+	if !strings.HasSuffix(lines[4], "] end\n") {
+		t.Errorf("TRACE record logged: %q", lines[4])
+	}
+}
+
+func TestVModuleNoMatch(t *testing.T) {
+	l, c := newVModuleLogger(t, "handler*=trace,fileWriter.go=debug")
+	l.Debug("debug")
+	l.DebugSort([]string{"k"}, []interface{}{"v"})
+	l.Info("info")
+	if line := c.next(t, 1)[0]; !strings.HasSuffix(line, "] info\n") {
+		t.Fatalf("got %q, want only the INFO record", line)
+	}
+}
+
+func TestParseVModule(t *testing.T) {
+	vm, err := parseVModule(" a.go=debug , b*=trace,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(vm.rules) != 2 || vm.min != levelRank(TRACE) {
+		t.Fatalf("got %+v", vm)
+	}
+	if vm, err := parseVModule(""); vm != nil || err != nil {
+		t.Fatalf("empty spec: %v %v", vm, err)
+	}
+	for _, spec := range []string{"a.go", "=debug", "a.go=loud", "[=debug"} {
+		if _, err := parseVModule(spec); err == nil {
+			t.Errorf("%q accepted", spec)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+// Command clogtool works on files written by clog.
+//
+//	clogtool verify [-key file:PATH|env:NAME] [-enckey file:PATH|env:NAME] FILE...
+//	clogtool decrypt -key file:PATH|env:NAME FILE...
+//
+// verify checks the hash chain of audit log files, given oldest first,
+// and exits with status 1 at the first broken or missing record. With
+// -enckey the files are encrypted and decrypted before being verified.
+//
+// decrypt writes the plain text of encrypted log files to stdout. A file
+// cut in the middle of a frame is decrypted up to that frame with a
+// warning.
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+
+	logger "github.com/forge1yc/clog/clog"
+)
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: clogtool verify [-key file:PATH|env:NAME] [-enckey file:PATH|env:NAME] FILE...")
+	fmt.Fprintln(os.Stderr, "       clogtool decrypt -key file:PATH|env:NAME FILE...")
+	os.Exit(2)
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	switch os.Args[1] {
+	case "verify":
+		os.Exit(verify(os.Args[2:]))
+	case "decrypt":
+		os.Exit(decrypt(os.Args[2:]))
+	default:
+		usage()
+	}
+}
+
+func verify(args []string) int {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	keySpec := fs.String("key", "", "HMAC key of the chain, file:PATH or env:NAME")
+	encKeySpec := fs.String("enckey", "", "AES key of encrypted files, file:PATH or env:NAME")
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		usage()
+	}
+
+	var key []byte
+	if *keySpec != "" {
+		var err error
+		if key, err = logger.LoadKey(*keySpec); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+	}
+
+	var (
+		report *logger.AuditReport
+		err    error
+	)
+	if *encKeySpec != "" {
+		encKey, kerr := logger.LoadKey(*encKeySpec)
+		if kerr != nil {
+			fmt.Fprintln(os.Stderr, kerr)
+			return 2
+		}
+		report, err = logger.VerifyEncryptedAuditLog(key, encKey, fs.Args()...)
+	} else {
+		report, err = logger.VerifyAuditLog(key, fs.Args()...)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	fmt.Println(report)
+	if report.Broken {
+		return 1
+	}
+	return 0
+}
+
+func decrypt(args []string) int {
+	fs := flag.NewFlagSet("decrypt", flag.ExitOnError)
+	keySpec := fs.String("key", "", "AES key, file:PATH or env:NAME")
+	fs.Parse(args)
+	if fs.NArg() == 0 || *keySpec == "" {
+		usage()
+	}
+
+	key, err := logger.LoadKey(*keySpec)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+	status := 0
+	for _, name := range fs.Args() {
+		f, err := os.Open(name)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+		err = logger.DecryptLog(out, f, key)
+		f.Close()
+		if err == logger.ErrTruncatedLog {
+			fmt.Fprintf(os.Stderr, "%s: warning: %v\n", name, err)
+			continue
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", name, err)
+			status = 1
+		}
+	}
+	return status
+}